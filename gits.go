@@ -58,6 +58,42 @@ func (g *Gits) MapData(data transport.TransportEntity) transport.TransportEntity
 	return g.storage.MapTransportData(data)
 }
 
+// runs the query and copies the matched entities and everything within
+// traverseDepth relations (in both directions) into a new registered
+// instance with the given name. entity types and ids are preserved.
+// returns nil if the name is already in use
+func (g *Gits) ExtractSubgraph(qry *query.Query, traverseDepth int, name string) *Gits {
+	ret, _ := g.extractSubgraph(qry, traverseDepth, name, false)
+	return ret
+}
+
+// works like ExtractSubgraph but assigns new ids to the copied
+// entities, starting at 1 per type in order of their old ids. also
+// returns the new address of each copied entity by its old address.
+// returns nil, nil if the name is already in use
+func (g *Gits) ExtractSubgraphRemapped(qry *query.Query, traverseDepth int, name string) (*Gits, map[[2]int][2]int) {
+	return g.extractSubgraph(qry, traverseDepth, name, true)
+}
+
+func (g *Gits) extractSubgraph(qry *query.Query, traverseDepth int, name string, remapIDs bool) (*Gits, map[[2]int][2]int) {
+	// no need to copy anything if the name is already in use
+	if instances.Exists(name) {
+		fmt.Println("Name already in use : '" + name + "'")
+		return nil, nil
+	}
+	extracted, addressMap := query.ExtractSubgraph(g.storage, qry, traverseDepth, remapIDs)
+	inst := &Gits{
+		Name:    name,
+		storage: extracted,
+		logs:    log.Logger{},
+	}
+	instances.Add(name, inst)
+	if ret := instances.GetByName(name); ret == inst {
+		return ret, addressMap
+	}
+	return nil, nil
+}
+
 func (g *Gits) Query() *QueryAdapter {
 	return &QueryAdapter{
 		storage: g.storage,
@@ -76,6 +112,10 @@ func (qa *QueryAdapter) Execute(qry *query.Query) transport.Transport {
 	return query.Execute(qa.storage, qry)
 }
 
+func (qa *QueryAdapter) ExecutePaths(from *query.Query, to *query.Query, opts storage.PathOptions) (transport.Transport, error) {
+	return query.ExecutePaths(qa.storage, from, to, opts)
+}
+
 type instanceIndex map[string]*Gits
 
 func (ii instanceIndex) Add(name string, gitsInst *Gits) {
@@ -93,6 +133,13 @@ func (ii instanceIndex) Add(name string, gitsInst *Gits) {
 	return
 }
 
+func (ii instanceIndex) Exists(name string) bool {
+	instanceMutex.RLock()
+	_, ok := instances[name]
+	instanceMutex.RUnlock()
+	return ok
+}
+
 func (ii instanceIndex) GetDefault() *Gits {
 	instanceMutex.RLock()
 	ret := defaultInstance
@@ -0,0 +1,63 @@
+package gits
+
+import (
+	"testing"
+
+	"github.com/voodooEntity/gits/src/types"
+)
+
+// creates an instance with the task chain 1 -> 2 -> 3 -> 4
+func createTaskInstance(name string) (*Gits, int) {
+	inst := NewInstance(name)
+	taskType, _ := inst.Storage().CreateEntityType("Task")
+	for i := 1; i <= 4; i++ {
+		inst.Storage().CreateEntity(types.StorageEntity{Type: taskType, Value: "task"})
+	}
+	for i := 1; i < 4; i++ {
+		inst.Storage().CreateRelation(taskType, i, taskType, i+1, types.StorageRelation{})
+	}
+	return inst, taskType
+}
+
+func TestExtractSubgraph(t *testing.T) {
+	inst, taskType := createTaskInstance("extractSource")
+	qry := inst.Query().New().Read("Task").Match("ID", "==", "3")
+
+	sub := inst.ExtractSubgraph(qry, 1, "extractTarget")
+	if nil == sub || sub != GetByName("extractTarget") {
+		t.Fatal("expected the extracted instance to be registered")
+	}
+	if 3 != len(sub.Storage().EntityStorage[taskType]) || !sub.Storage().EntityExists(taskType, 2) || !sub.Storage().EntityExists(taskType, 4) {
+		t.Error("expected tasks 2 to 4 with their ids", sub.Storage().EntityStorage)
+	}
+	if !sub.Storage().RelationExists(taskType, 2, taskType, 3) || !sub.Storage().RelationExists(taskType, 3, taskType, 4) {
+		t.Error("expected the relations between the tasks to be copied", sub.Storage().RelationStorage)
+	}
+	if nil != inst.ExtractSubgraph(qry, 1, "extractTarget") || sub != GetByName("extractTarget") {
+		t.Error("expected nil and the existing instance to be kept if the name is already in use")
+	}
+}
+
+func TestExtractSubgraphRemapped(t *testing.T) {
+	inst, taskType := createTaskInstance("extractRemappedSource")
+	qry := inst.Query().New().Read("Task").Match("ID", "==", "3")
+
+	sub, addressMap := inst.ExtractSubgraphRemapped(qry, 1, "extractRemappedTarget")
+	if nil == sub || sub != GetByName("extractRemappedTarget") {
+		t.Fatal("expected the extracted instance to be registered")
+	}
+	if 3 != len(addressMap) {
+		t.Error("expected an address for each copied task", addressMap)
+	}
+	for old, expected := range map[int]int{2: 1, 3: 2, 4: 3} {
+		if [2]int{taskType, expected} != addressMap[[2]int{taskType, old}] || !sub.Storage().EntityExists(taskType, expected) {
+			t.Error("expected task", old, "to be remapped to", expected, addressMap)
+		}
+	}
+	if !sub.Storage().RelationExists(taskType, 1, taskType, 2) || !sub.Storage().RelationExists(taskType, 2, taskType, 3) {
+		t.Error("expected the relations to use the new ids", sub.Storage().RelationStorage)
+	}
+	if sub, addressMap := inst.ExtractSubgraphRemapped(qry, 1, "extractRemappedTarget"); nil != sub || nil != addressMap {
+		t.Error("expected nil if the name is already in use")
+	}
+}
@@ -0,0 +1,187 @@
+package algo
+
+import (
+	"math"
+	"testing"
+
+	"github.com/voodooEntity/gits/src/storage"
+	"github.com/voodooEntity/gits/src/types"
+)
+
+// creates user1 -> user2 -> user3 -> user1, user3 -> device1,
+// an isolated device2 and a device3 with the context "other"
+func createTestGraph() (*storage.Storage, int, int) {
+	store := storage.NewStorage()
+	userType, _ := store.CreateEntityType("User")
+	deviceType, _ := store.CreateEntityType("Device")
+	for i := 0; i < 3; i++ {
+		store.CreateEntity(types.StorageEntity{Type: userType, Value: "user"})
+	}
+	store.CreateEntity(types.StorageEntity{Type: deviceType, Value: "device"})
+	store.CreateEntity(types.StorageEntity{Type: deviceType, Value: "device"})
+	store.CreateEntity(types.StorageEntity{Type: deviceType, Value: "device", Context: "other"})
+	link := func(srcType int, srcID int, targetType int, targetID int) {
+		store.CreateRelation(srcType, srcID, targetType, targetID, types.StorageRelation{SourceType: srcType, SourceID: srcID, TargetType: targetType, TargetID: targetID})
+	}
+	link(userType, 1, userType, 2)
+	link(userType, 2, userType, 3)
+	link(userType, 3, userType, 1)
+	link(userType, 3, deviceType, 1)
+	link(userType, 1, deviceType, 3)
+	return store, userType, deviceType
+}
+
+func TestConnectedComponents(t *testing.T) {
+	store, userType, deviceType := createTestGraph()
+
+	weak := WeaklyConnectedComponents(store, Options{})
+	if 6 != len(weak) || 1 != weak[[2]int{userType, 3}] || 1 != weak[[2]int{deviceType, 1}] || 1 != weak[[2]int{deviceType, 3}] || 2 != weak[[2]int{deviceType, 2}] {
+		t.Error("unexpected weakly connected components", weak)
+	}
+
+	strong := StronglyConnectedComponents(store, Options{})
+	if 1 != strong[[2]int{userType, 1}] || 1 != strong[[2]int{userType, 3}] || 2 != strong[[2]int{deviceType, 1}] || 4 != strong[[2]int{deviceType, 3}] {
+		t.Error("unexpected strongly connected components", strong)
+	}
+
+	weak = WeaklyConnectedComponents(store, Options{Types: []string{"Device"}})
+	if 3 != len(weak) || 3 != weak[[2]int{deviceType, 3}] {
+		t.Error("devices should not be connected without users", weak)
+	}
+}
+
+func TestPageRankAndDegree(t *testing.T) {
+	store, userType, deviceType := createTestGraph()
+	opts := Options{}
+
+	ranks := PageRank(store, Options{Types: []string{"User"}}, 0, 0)
+	if 3 != len(ranks) || 0.0001 < ranks[[2]int{userType, 1}]-1.0/3 || 0.0001 < 1.0/3-ranks[[2]int{userType, 1}] {
+		t.Error("users in a cycle should share the rank evenly", ranks)
+	}
+	ranks = PageRank(store, opts, 0, 0)
+	if ranks[[2]int{deviceType, 1}] <= ranks[[2]int{deviceType, 2}] {
+		t.Error("linked device should rank higher than isolated one", ranks)
+	}
+
+	degrees := DegreeCentrality(store, opts, storage.DIRECTION_CHILD)
+	if 2 != degrees[[2]int{userType, 3}] || 0 != degrees[[2]int{deviceType, 1}] {
+		t.Error("unexpected out degrees", degrees)
+	}
+	degrees = DegreeCentrality(store, Options{Context: "other"}, storage.DIRECTION_BOTH)
+	if 1 != len(degrees) || 0 != degrees[[2]int{deviceType, 3}] {
+		t.Error("only device3 should be part of the context", degrees)
+	}
+}
+
+func TestPageRankZeroWeights(t *testing.T) {
+	store := storage.NewStorage()
+	taskType, _ := store.CreateEntityType("Task")
+	for i := 0; i < 3; i++ {
+		store.CreateEntity(types.StorageEntity{Type: taskType, Value: "task"})
+	}
+	store.CreateRelation(taskType, 1, taskType, 2, types.StorageRelation{SourceType: taskType, SourceID: 1, TargetType: taskType, TargetID: 2, Properties: map[string]string{"w": "0"}})
+	store.CreateRelation(taskType, 2, taskType, 3, types.StorageRelation{SourceType: taskType, SourceID: 2, TargetType: taskType, TargetID: 3, Properties: map[string]string{"w": "1"}})
+
+	ranks := PageRank(store, Options{WeightProperty: "w"}, 0, 0)
+	sum := 0.0
+	for _, rank := range ranks {
+		if math.IsNaN(rank) {
+			t.Fatal("expected no NaN ranks", ranks)
+		}
+		sum += rank
+	}
+	if 0.0001 < math.Abs(1-sum) || ranks[[2]int{taskType, 3}] <= ranks[[2]int{taskType, 2}] {
+		t.Error("unexpected ranks for zero weights", ranks)
+	}
+}
+
+func TestBetweennessCentralityAndWrite(t *testing.T) {
+	store, userType, deviceType := createTestGraph()
+
+	scores := BetweennessCentrality(store, Options{})
+	// user3 is on the only shortest paths user2 -> user1,
+	// user2 -> device1, user1 -> device1 and user2 -> device3
+	if 4 != scores[[2]int{userType, 3}] || 0 != scores[[2]int{deviceType, 1}] {
+		t.Error("unexpected betweenness", scores)
+	}
+
+	if 6 != scores.Write(store, "betweenness") {
+		t.Error("expected all entities to be updated")
+	}
+	entity, _ := store.GetEntityByPath(userType, 3, "")
+	if "4" != entity.Properties["betweenness"] || 2 != entity.Version {
+		t.Error("score should have been written to properties", entity)
+	}
+}
+
+// creates two triangles of users 1-3 and 4-6 which are
+// connected by a single relation from user3 to user4
+func createCommunityGraph() (*storage.Storage, int) {
+	store := storage.NewStorage()
+	userType, _ := store.CreateEntityType("User")
+	for i := 0; i < 6; i++ {
+		store.CreateEntity(types.StorageEntity{Type: userType, Value: "user"})
+	}
+	for _, pair := range [][2]int{{1, 2}, {2, 3}, {3, 1}, {4, 5}, {5, 6}, {6, 4}, {3, 4}} {
+		store.CreateRelation(userType, pair[0], userType, pair[1], types.StorageRelation{SourceType: userType, SourceID: pair[0], TargetType: userType, TargetID: pair[1]})
+	}
+	return store, userType
+}
+
+func TestCommunities(t *testing.T) {
+	store, userType := createCommunityGraph()
+	expected := [][][2]int{
+		{{userType, 1}, {userType, 2}, {userType, 3}},
+		{{userType, 4}, {userType, 5}, {userType, 6}},
+	}
+
+	for name, communities := range map[string]Components{
+		"label propagation": LabelPropagation(store, Options{}, 0),
+		"louvain":           Louvain(store, Options{}),
+	} {
+		groups := communities.Groups()
+		if 2 != len(groups) || 3 != len(groups[0]) || 3 != len(groups[1]) {
+			t.Error(name, "expected two triangles", groups)
+			continue
+		}
+		for key, group := range groups {
+			for member, address := range group {
+				if expected[key][member] != address {
+					t.Error(name, "unexpected community member", groups)
+				}
+			}
+		}
+	}
+
+	if 6 != Louvain(store, Options{}).Write(store, "community") {
+		t.Error("expected community to be written to all users")
+	}
+	entity, _ := store.GetEntityByPath(userType, 6, "")
+	if "2" != entity.Properties["community"] {
+		t.Error("expected user6 to be part of community 2", entity)
+	}
+}
+
+func TestLouvainWeighted(t *testing.T) {
+	store := storage.NewStorage()
+	userType, _ := store.CreateEntityType("User")
+	for i := 0; i < 4; i++ {
+		store.CreateEntity(types.StorageEntity{Type: userType, Value: "user"})
+	}
+	link := func(source int, target int, weight string) {
+		store.CreateRelation(userType, source, userType, target, types.StorageRelation{SourceType: userType, SourceID: source, TargetType: userType, TargetID: target, Properties: map[string]string{"weight": weight}})
+	}
+	link(1, 2, "1")
+	link(3, 4, "1")
+	link(1, 3, "5")
+	link(2, 4, "5")
+
+	communities := Louvain(store, Options{WeightProperty: "weight"})
+	if communities[[2]int{userType, 1}] != communities[[2]int{userType, 3}] || communities[[2]int{userType, 2}] != communities[[2]int{userType, 4}] || communities[[2]int{userType, 1}] == communities[[2]int{userType, 2}] {
+		t.Error("expected heavy relations to form the communities", communities)
+	}
+	communities = LabelPropagation(store, Options{WeightProperty: "weight"}, 0)
+	if communities[[2]int{userType, 1}] != communities[[2]int{userType, 3}] || communities[[2]int{userType, 1}] == communities[[2]int{userType, 2}] {
+		t.Error("expected heavy relations to form the label communities", communities)
+	}
+}
@@ -0,0 +1,148 @@
+package algo
+
+import (
+	"math"
+
+	"github.com/voodooEntity/gits/src/storage"
+)
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// computes the pagerank of all entities following relations
+// from source to target. damping defaults to 0.85 and iterations
+// to 100 if given 0 or less. the calculation stops early if the
+// ranks don't change anymore. entities without children or with
+// only zero weighted relations distribute their rank evenly to
+// all entities. if a WeightProperty is given the rank is split
+// by relation weight
+func PageRank(store *storage.Storage, opts Options, damping float64, iterations int) Scores {
+	g := newGraph(store, opts)
+	if 0 >= damping {
+		damping = 0.85
+	}
+	if 0 >= iterations {
+		iterations = 100
+	}
+
+	amount := float64(len(g.nodes))
+	ranks := make([]float64, len(g.nodes))
+	for node := range ranks {
+		ranks[node] = 1 / amount
+	}
+	outWeights := make([]float64, len(g.nodes))
+	for node := range g.nodes {
+		for _, target := range g.out[node] {
+			outWeights[node] += target.weight
+		}
+	}
+
+	for i := 0; i < iterations; i++ {
+		dangling := 0.0
+		for node, rank := range ranks {
+			if 0 == outWeights[node] {
+				dangling += rank
+			}
+		}
+		next := make([]float64, len(g.nodes))
+		diff := 0.0
+		for node := range g.nodes {
+			sum := 0.0
+			for _, source := range g.in[node] {
+				// sources with only zero weights count as dangling
+				if 0 == outWeights[source.node] {
+					continue
+				}
+				sum += ranks[source.node] * source.weight / outWeights[source.node]
+			}
+			next[node] = (1-damping)/amount + damping*(sum+dangling/amount)
+			diff += math.Abs(next[node] - ranks[node])
+		}
+		ranks = next
+		if 1e-10 > diff {
+			break
+		}
+	}
+
+	ret := make(Scores, len(g.nodes))
+	for node, address := range g.nodes {
+		ret[address] = ranks[node]
+	}
+	return ret
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// returns the amount of relations per entity. direction
+// storage.DIRECTION_CHILD counts relations towards children,
+// storage.DIRECTION_PARENT towards parents and
+// storage.DIRECTION_BOTH counts all of them
+func DegreeCentrality(store *storage.Storage, opts Options, direction int) Scores {
+	g := newGraph(store, opts)
+	ret := make(Scores, len(g.nodes))
+	for node, address := range g.nodes {
+		degree := 0
+		if storage.DIRECTION_CHILD == direction || storage.DIRECTION_BOTH == direction {
+			degree += len(g.out[node])
+		}
+		if storage.DIRECTION_PARENT == direction || storage.DIRECTION_BOTH == direction {
+			degree += len(g.in[node])
+		}
+		ret[address] = float64(degree)
+	}
+	return ret
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// computes the betweenness centrality of all entities, which is
+// the amount of shortest paths between other entities passing
+// through an entity. paths follow relations from source to target
+// and the scores are not normalized
+func BetweennessCentrality(store *storage.Storage, opts Options) Scores {
+	g := newGraph(store, opts)
+	scores := make([]float64, len(g.nodes))
+
+	// brandes' algorithm
+	for source := range g.nodes {
+		var stack []int
+		predecessors := make([][]int, len(g.nodes))
+		paths := make([]float64, len(g.nodes))
+		distance := make([]int, len(g.nodes))
+		for node := range distance {
+			distance[node] = -1
+		}
+		paths[source] = 1
+		distance[source] = 0
+
+		queue := []int{source}
+		for 0 < len(queue) {
+			node := queue[0]
+			queue = queue[1:]
+			stack = append(stack, node)
+			for _, target := range g.out[node] {
+				if 0 > distance[target.node] {
+					distance[target.node] = distance[node] + 1
+					queue = append(queue, target.node)
+				}
+				if distance[target.node] == distance[node]+1 {
+					paths[target.node] += paths[node]
+					predecessors[target.node] = append(predecessors[target.node], node)
+				}
+			}
+		}
+
+		dependency := make([]float64, len(g.nodes))
+		for i := len(stack) - 1; i >= 0; i-- {
+			node := stack[i]
+			for _, predecessor := range predecessors[node] {
+				dependency[predecessor] += paths[predecessor] / paths[node] * (1 + dependency[node])
+			}
+			if node != source {
+				scores[node] += dependency[node]
+			}
+		}
+	}
+
+	ret := make(Scores, len(g.nodes))
+	for node, address := range g.nodes {
+		ret[address] = scores[node]
+	}
+	return ret
+}
@@ -0,0 +1,206 @@
+package algo
+
+import (
+	"math/rand"
+	"sort"
+
+	"github.com/voodooEntity/gits/src/storage"
+)
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// detects communities by label propagation. every entity starts
+// with its own label and repeatedly adopts the label with the highest
+// relation weight among its neighbours until no label changes or the
+// given amount of iterations is reached (defaults to 100 if 0 or less).
+// relations are followed regardless of their direction. entities are
+// processed in random order and ties are resolved randomly unless the
+// current label is one of the best. a fixed seed is used so the result
+// is reproducible for the same graph. community ids start at 1
+func LabelPropagation(store *storage.Storage, opts Options, iterations int) Components {
+	g := newGraph(store, opts)
+	if 0 >= iterations {
+		iterations = 100
+	}
+
+	labels := make([]int, len(g.nodes))
+	for node := range labels {
+		labels[node] = node
+	}
+
+	random := rand.New(rand.NewSource(1))
+	order := make([]int, len(g.nodes))
+	for node := range order {
+		order[node] = node
+	}
+
+	for i := 0; i < iterations; i++ {
+		changed := false
+		random.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		for _, node := range order {
+			weights := make(map[int]float64)
+			for _, neighbour := range g.neighbours(node) {
+				if neighbour.node != node {
+					weights[labels[neighbour.node]] += neighbour.weight
+				}
+			}
+			if 0 == len(weights) {
+				continue
+			}
+			maxWeight := 0.0
+			for _, weight := range weights {
+				if weight > maxWeight {
+					maxWeight = weight
+				}
+			}
+			if weights[labels[node]] == maxWeight {
+				continue
+			}
+			// collect the best labels sorted so the
+			// random pick only depends on the seed
+			var candidates []int
+			for label, weight := range weights {
+				if weight == maxWeight {
+					candidates = append(candidates, label)
+				}
+			}
+			sort.Ints(candidates)
+			labels[node] = candidates[random.Intn(len(candidates))]
+			changed = true
+		}
+		if !changed {
+			break
+		}
+	}
+
+	return g.numberComponents(labels)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// detects communities using the louvain method, which groups entities
+// so the modularity of the graph gets maximized. relations are treated
+// as undirected, relations existing in both directions add up their
+// weights. entities and candidate communities are processed in a
+// fixed order so the result is reproducible. community ids start at 1
+func Louvain(store *storage.Storage, opts Options) Components {
+	g := newGraph(store, opts)
+
+	// build the undirected weighted adjacency of the first level
+	adjacency := make([]map[int]float64, len(g.nodes))
+	for node := range g.nodes {
+		adjacency[node] = make(map[int]float64)
+	}
+	for source := range g.nodes {
+		for _, target := range g.out[source] {
+			adjacency[source][target.node] += target.weight
+			adjacency[target.node][source] += target.weight
+		}
+	}
+
+	// membership of every entity to a node of the current level
+	membership := make([]int, len(g.nodes))
+	for node := range membership {
+		membership[node] = node
+	}
+
+	for {
+		communities, moved := louvainLocalMoving(adjacency)
+		if !moved {
+			break
+		}
+		// renumber the communities so they can be used as nodes
+		// of the next level
+		ids := make(map[int]int)
+		for _, community := range communities {
+			if _, ok := ids[community]; !ok {
+				ids[community] = len(ids)
+			}
+		}
+		for node, current := range membership {
+			membership[node] = ids[communities[current]]
+		}
+		// aggregate every community into a single node
+		next := make([]map[int]float64, len(ids))
+		for node := range next {
+			next[node] = make(map[int]float64)
+		}
+		for source, targets := range adjacency {
+			for target, weight := range targets {
+				next[ids[communities[source]]][ids[communities[target]]] += weight
+			}
+		}
+		adjacency = next
+	}
+
+	return g.numberComponents(membership)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// + + + + + + + + + +  PRIVATE  + + + + + + + + + + +
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// moves single nodes into neighbouring communities as long as this
+// increases the modularity. returns the community per node and if
+// any node has been moved at all
+func louvainLocalMoving(adjacency []map[int]float64) ([]int, bool) {
+	communities := make([]int, len(adjacency))
+	degrees := make([]float64, len(adjacency))
+	totals := make([]float64, len(adjacency))
+	total := 0.0
+	for node, targets := range adjacency {
+		communities[node] = node
+		for _, weight := range targets {
+			degrees[node] += weight
+		}
+		totals[node] = degrees[node]
+		total += degrees[node]
+	}
+	if 0 == total {
+		return communities, false
+	}
+
+	moved := false
+	for {
+		changed := false
+		for node, targets := range adjacency {
+			current := communities[node]
+			totals[current] -= degrees[node]
+
+			// sum up the weights towards each neighbouring community
+			links := make(map[int]float64)
+			for target, weight := range targets {
+				if target != node {
+					links[communities[target]] += weight
+				}
+			}
+			candidates := make([]int, 0, len(links))
+			for community := range links {
+				candidates = append(candidates, community)
+			}
+			sort.Ints(candidates)
+
+			// the gain of joining a community is proportional to
+			// links - totals * degree / total. we only move if
+			// the gain is higher than staying
+			best := current
+			bestGain := links[current] - totals[current]*degrees[node]/total
+			for _, community := range candidates {
+				gain := links[community] - totals[community]*degrees[node]/total
+				if gain > bestGain+1e-12 {
+					best = community
+					bestGain = gain
+				}
+			}
+
+			totals[best] += degrees[node]
+			if best != current {
+				communities[node] = best
+				changed = true
+				moved = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	return communities, moved
+}
@@ -0,0 +1,128 @@
+package algo
+
+import (
+	"github.com/voodooEntity/gits/src/storage"
+)
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// computes the weakly connected components, relations are
+// followed regardless of their direction. component ids start
+// at 1 and are assigned in order of the lowest entity address
+// of each component
+func WeaklyConnectedComponents(store *storage.Storage, opts Options) Components {
+	g := newGraph(store, opts)
+
+	parent := make([]int, len(g.nodes))
+	for key := range parent {
+		parent[key] = key
+	}
+	var find func(node int) int
+	find = func(node int) int {
+		if parent[node] != node {
+			parent[node] = find(parent[node])
+		}
+		return parent[node]
+	}
+	for source := range g.nodes {
+		for _, target := range g.out[source] {
+			rootSource, rootTarget := find(source), find(target.node)
+			// the lower index always becomes the root
+			if rootSource < rootTarget {
+				parent[rootTarget] = rootSource
+			} else if rootTarget < rootSource {
+				parent[rootSource] = rootTarget
+			}
+		}
+	}
+
+	roots := make([]int, len(g.nodes))
+	for key := range g.nodes {
+		roots[key] = find(key)
+	}
+	return g.numberComponents(roots)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// computes the strongly connected components, two entities share
+// a component if each of them can be reached from the other following
+// the relation direction. component ids start at 1 and are assigned
+// in order of the lowest entity address of each component
+func StronglyConnectedComponents(store *storage.Storage, opts Options) Components {
+	g := newGraph(store, opts)
+
+	// tarjan's algorithm
+	index := make([]int, len(g.nodes))
+	lowLink := make([]int, len(g.nodes))
+	onStack := make([]bool, len(g.nodes))
+	roots := make([]int, len(g.nodes))
+	var stack []int
+	counter := 1
+
+	var visit func(node int)
+	visit = func(node int) {
+		index[node] = counter
+		lowLink[node] = counter
+		counter++
+		stack = append(stack, node)
+		onStack[node] = true
+
+		for _, target := range g.out[node] {
+			if 0 == index[target.node] {
+				visit(target.node)
+				if lowLink[target.node] < lowLink[node] {
+					lowLink[node] = lowLink[target.node]
+				}
+			} else if onStack[target.node] && index[target.node] < lowLink[node] {
+				lowLink[node] = index[target.node]
+			}
+		}
+
+		// node is the root of a component, everything
+		// above it on the stack belongs to it
+		if lowLink[node] == index[node] {
+			var members []int
+			lowest := node
+			for {
+				member := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[member] = false
+				members = append(members, member)
+				if member < lowest {
+					lowest = member
+				}
+				if member == node {
+					break
+				}
+			}
+			for _, member := range members {
+				roots[member] = lowest
+			}
+		}
+	}
+
+	for node := range g.nodes {
+		if 0 == index[node] {
+			visit(node)
+		}
+	}
+	return g.numberComponents(roots)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// + + + + + + + + + +  PRIVATE  + + + + + + + + + + +
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// translates the lowest node index of every component into
+// component ids starting at 1. since nodes are sorted the
+// first node seen of every component is its root
+func (g *graph) numberComponents(roots []int) Components {
+	ret := make(Components, len(g.nodes))
+	ids := make(map[int]int)
+	for node, root := range roots {
+		if _, ok := ids[root]; !ok {
+			ids[root] = len(ids) + 1
+		}
+		ret[g.nodes[node]] = ids[root]
+	}
+	return ret
+}
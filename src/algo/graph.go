@@ -0,0 +1,216 @@
+package algo
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/voodooEntity/gits/src/storage"
+)
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// options to define which part of the storage an
+// algorithm runs on
+type Options struct {
+	// entity types to include, empty means all types
+	Types []string
+	// only entities with this context are included,
+	// empty means all entities
+	Context string
+	// name of a relation property holding the weight of a relation.
+	// relations without a valid, non negative weight count 1.
+	// empty means all relations count 1
+	WeightProperty string
+}
+
+// component id per entity address [type, id]
+type Components map[[2]int]int
+
+// score per entity address [type, id]
+type Scores map[[2]int]float64
+
+// a snapshot of the relevant part of the storage. every entity
+// is represented by its index in the sorted address list so
+// the algorithms don't have to hold any storage lock
+type graph struct {
+	nodes [][2]int
+	index map[[2]int]int
+	out   [][]edge
+	in    [][]edge
+}
+
+type edge struct {
+	node   int
+	weight float64
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// writes the component ids into the given property of the
+// entities. entities deleted in the meantime are skipped.
+// returns the amount of updated entities
+func (c Components) Write(store *storage.Storage, property string) int {
+	values := make(map[[2]int]string, len(c))
+	for address, component := range c {
+		values[address] = strconv.Itoa(component)
+	}
+	return writeProperty(store, values, property)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// returns the entity addresses grouped by component. groups
+// are ordered by component id and addresses inside a group
+// by type and id
+func (c Components) Groups() [][][2]int {
+	var addresses [][2]int
+	for address := range c {
+		addresses = append(addresses, address)
+	}
+	sortAddresses(addresses)
+	groups := make(map[int][][2]int)
+	var ids []int
+	for _, address := range addresses {
+		if _, ok := groups[c[address]]; !ok {
+			ids = append(ids, c[address])
+		}
+		groups[c[address]] = append(groups[c[address]], address)
+	}
+	sort.Ints(ids)
+	ret := make([][][2]int, len(ids))
+	for key, id := range ids {
+		ret[key] = groups[id]
+	}
+	return ret
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// writes the scores into the given property of the
+// entities. entities deleted in the meantime are skipped.
+// returns the amount of updated entities
+func (sc Scores) Write(store *storage.Storage, property string) int {
+	values := make(map[[2]int]string, len(sc))
+	for address, score := range sc {
+		values[address] = strconv.FormatFloat(score, 'f', -1, 64)
+	}
+	return writeProperty(store, values, property)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// + + + + + + + + + +  PRIVATE  + + + + + + + + + + +
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// builds the graph snapshot while holding read locks on the storage
+func newGraph(store *storage.Storage, opts Options) *graph {
+	store.EntityTypeMutex.RLock()
+	store.EntityStorageMutex.RLock()
+	store.RelationStorageMutex.RLock()
+	defer store.RelationStorageMutex.RUnlock()
+	defer store.EntityStorageMutex.RUnlock()
+	defer store.EntityTypeMutex.RUnlock()
+
+	allowedTypes := make(map[int]bool)
+	if 0 == len(opts.Types) {
+		for typeID := range store.EntityTypes {
+			allowedTypes[typeID] = true
+		}
+	} else {
+		for _, typeName := range opts.Types {
+			if typeID, ok := store.EntityRTypes[typeName]; ok {
+				allowedTypes[typeID] = true
+			}
+		}
+	}
+
+	g := &graph{index: make(map[[2]int]int)}
+	for typeID := range allowedTypes {
+		for entityID, entity := range store.EntityStorage[typeID] {
+			if "" != opts.Context && entity.Context != opts.Context {
+				continue
+			}
+			g.nodes = append(g.nodes, [2]int{typeID, entityID})
+		}
+	}
+	sortAddresses(g.nodes)
+	for key, address := range g.nodes {
+		g.index[address] = key
+	}
+
+	g.out = make([][]edge, len(g.nodes))
+	g.in = make([][]edge, len(g.nodes))
+	for source, address := range g.nodes {
+		for targetType, targets := range store.RelationStorage[address[0]][address[1]] {
+			for targetID, relation := range targets {
+				target, ok := g.index[[2]int{targetType, targetID}]
+				if !ok {
+					continue
+				}
+				weight := getWeight(relation.Properties, opts.WeightProperty)
+				g.out[source] = append(g.out[source], edge{node: target, weight: weight})
+				g.in[target] = append(g.in[target], edge{node: source, weight: weight})
+			}
+		}
+	}
+	// keep the edge order stable so results are reproducible
+	for key := range g.nodes {
+		sortEdges(g.out[key])
+		sortEdges(g.in[key])
+	}
+	return g
+}
+
+// returns the neighbours of a node regardless of the relation direction
+func (g *graph) neighbours(node int) []edge {
+	ret := make([]edge, 0, len(g.out[node])+len(g.in[node]))
+	ret = append(ret, g.out[node]...)
+	return append(ret, g.in[node]...)
+}
+
+func getWeight(properties map[string]string, property string) float64 {
+	if "" == property {
+		return 1
+	}
+	weight, err := strconv.ParseFloat(properties[property], 64)
+	if nil != err || 0 > weight || math.IsNaN(weight) || math.IsInf(weight, 0) {
+		return 1
+	}
+	return weight
+}
+
+func sortAddresses(addresses [][2]int) {
+	sort.Slice(addresses, func(i, j int) bool {
+		if addresses[i][0] != addresses[j][0] {
+			return addresses[i][0] < addresses[j][0]
+		}
+		return addresses[i][1] < addresses[j][1]
+	})
+}
+
+func sortEdges(edges []edge) {
+	sort.Slice(edges, func(i, j int) bool {
+		return edges[i].node < edges[j].node
+	})
+}
+
+func writeProperty(store *storage.Storage, values map[[2]int]string, property string) int {
+	store.EntityStorageMutex.Lock()
+	defer store.EntityStorageMutex.Unlock()
+
+	updated := 0
+	for address, value := range values {
+		entity, ok := store.EntityStorage[address[0]][address[1]]
+		if !ok {
+			continue
+		}
+		// copy the properties so we don't modify
+		// maps that may have been handed out before
+		properties := make(map[string]string, len(entity.Properties)+1)
+		for key, val := range entity.Properties {
+			properties[key] = val
+		}
+		properties[property] = value
+		entity.Properties = properties
+		if nil == store.UpdateEntityUnsafe(entity) {
+			updated++
+		}
+	}
+	return updated
+}
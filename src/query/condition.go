@@ -0,0 +1,145 @@
+package query
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/voodooEntity/gits/src/storage"
+)
+
+// types of conditions
+const (
+	CONDITION_MATCH = "Match"
+	CONDITION_AND   = storage.CONDITION_AND
+	CONDITION_OR    = storage.CONDITION_OR
+	CONDITION_NOT   = storage.CONDITION_NOT
+)
+
+// node of a condition tree. Match conditions compare a field,
+// And, Or and Not conditions combine the given Conditions
+type Condition struct {
+	Type       string
+	Match      [3]string
+	Conditions []Condition
+}
+
+// compares a field using the given operator, works like Match()
+func Cond(alpha string, operator string, beta string) Condition {
+	return Condition{
+		Type:  CONDITION_MATCH,
+		Match: [3]string{alpha, operator, beta},
+	}
+}
+
+// matches if all of the conditions match
+func And(conditions ...Condition) Condition {
+	return Condition{
+		Type:       CONDITION_AND,
+		Conditions: conditions,
+	}
+}
+
+// matches if any of the conditions matches
+func Or(conditions ...Condition) Condition {
+	return Condition{
+		Type:       CONDITION_OR,
+		Conditions: conditions,
+	}
+}
+
+// matches if none of the conditions matches
+func Not(conditions ...Condition) Condition {
+	return Condition{
+		Type:       CONDITION_NOT,
+		Conditions: conditions,
+	}
+}
+
+// adds a condition tree which has to match in addition to all
+// other conditions of the query
+func (self *Query) Where(condition Condition) *Query {
+	self.Filter = append(self.Filter, condition)
+	return self
+}
+
+// checks the condition trees of the query and its subqueries
+// for unknown condition types
+func (self *Query) Validate() error {
+	for _, condition := range self.Filter {
+		if err := condition.Validate(); nil != err {
+			return err
+		}
+	}
+	for key := range self.Map {
+		if err := self.Map[key].Validate(); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// checks the condition and its children for unknown types
+func (self Condition) Validate() error {
+	if "" == getConditionType(self) {
+		return errors.New("Invalid condition type " + self.Type + ".")
+	}
+	for _, child := range self.Conditions {
+		if err := child.Validate(); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the condition groups and the condition tree evaluated by
+// the storage. without Where() conditions the groups of Match() and
+// OrMatch() are used as they are and no tree is needed. each Match
+// condition of the tree becomes a group of its own
+func getConditions(qry Query) ([][][3]string, *storage.ConditionNode) {
+	if 0 == len(qry.Filter) {
+		return qry.Conditions, nil
+	}
+	conditions := append([][][3]string{}, qry.Conditions...)
+	root := storage.ConditionNode{Type: storage.CONDITION_AND}
+	if 0 < len(qry.Conditions) {
+		groups := storage.ConditionNode{Type: storage.CONDITION_OR}
+		for key := range qry.Conditions {
+			groups.Children = append(groups.Children, storage.ConditionNode{Type: storage.CONDITION_GROUP, Group: key})
+		}
+		root.Children = append(root.Children, groups)
+	}
+	for _, condition := range qry.Filter {
+		root.Children = append(root.Children, compileCondition(condition, &conditions))
+	}
+	return conditions, &root
+}
+
+// converts a condition into a storage condition node and adds its
+// Match conditions to the condition groups
+func compileCondition(condition Condition, conditions *[][][3]string) storage.ConditionNode {
+	conditionType := getConditionType(condition)
+	if CONDITION_MATCH == conditionType {
+		*conditions = append(*conditions, [][3]string{condition.Match})
+		return storage.ConditionNode{Type: storage.CONDITION_GROUP, Group: len(*conditions) - 1}
+	}
+	node := storage.ConditionNode{Type: conditionType}
+	for _, child := range condition.Conditions {
+		node.Children = append(node.Children, compileCondition(child, conditions))
+	}
+	return node
+}
+
+// returns the type of the condition ignoring the case. conditions
+// without type but with a Match are Match conditions. returns an
+// empty string for unknown types
+func getConditionType(condition Condition) string {
+	if "" == condition.Type && ([3]string{}) != condition.Match {
+		return CONDITION_MATCH
+	}
+	for _, conditionType := range []string{CONDITION_MATCH, CONDITION_AND, CONDITION_OR, CONDITION_NOT} {
+		if strings.EqualFold(conditionType, condition.Type) {
+			return conditionType
+		}
+	}
+	return ""
+}
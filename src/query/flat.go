@@ -0,0 +1,220 @@
+package query
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/voodooEntity/gits/src/transport"
+)
+
+// a query returning data in flat results. every read query of the
+// join tree gets a slot holding its columns
+type flatSlot struct {
+	Alias     string
+	Selection []string
+}
+
+// a column of a flat result
+type flatField struct {
+	Slot  int
+	Field string
+}
+
+// returns the slots of the query and its read subqueries in depth
+// first order. queries without alias are named by their types,
+// duplicate names are numbered
+func getFlatSlots(qry Query) []flatSlot {
+	var slots []flatSlot
+	used := make(map[string]int)
+	var walk func(qry Query)
+	walk = func(qry Query) {
+		alias := qry.Alias
+		if "" == alias {
+			alias = strings.Join(qry.Pool, "|")
+		}
+		used[alias]++
+		if 1 < used[alias] {
+			alias += "#" + strconv.Itoa(used[alias])
+		}
+		slots = append(slots, flatSlot{Alias: alias, Selection: getSelection(qry)})
+		for _, subQuery := range qry.Map {
+			if isFlatSlot(subQuery) {
+				walk(subQuery)
+			}
+		}
+	}
+	walk(qry)
+	return slots
+}
+
+// returns if the subquery has columns in flat results
+func isFlatSlot(qry Query) bool {
+	return METHOD_READ == qry.Method && !qry.Negated
+}
+
+// amount of slots used by a read query and its read subqueries
+func getFlatSlotCount(qry Query) int {
+	count := 1
+	for _, subQuery := range qry.Map {
+		if isFlatSlot(subQuery) {
+			count += getFlatSlotCount(subQuery)
+		}
+	}
+	return count
+}
+
+// the joins of an entity of the nested result. amounts holds the
+// amount of relations each subquery added to the entity, nested the
+// joins of the related entities by subquery and flat key
+type flatJoins struct {
+	amounts []int
+	nested  []map[string]*flatJoins
+}
+
+// builds one row per path through the join tree of the root entities
+// by walking their nested relations. joins of the same entity are
+// combined with each other, optional joins without a match leave
+// their columns empty. joins holds the joins of the root entities
+// by flat key
+func flattenResults(qry Query, entities []transport.TransportEntity, joins map[string]*flatJoins) ([]string, [][]string) {
+	slots := getFlatSlots(qry)
+	var rows []map[int]transport.TransportEntity
+	for _, entity := range entities {
+		for _, subRow := range flattenJoins(qry.Map, entity, joins[getFlatKey(entity)], 1) {
+			subRow[0] = entity
+			rows = append(rows, subRow)
+		}
+	}
+
+	// collect the columns of every slot
+	var columns []string
+	var fields []flatField
+	for index, slot := range slots {
+		for _, field := range getFlatFields(slot.Selection, rows, index) {
+			columns = append(columns, slot.Alias+"."+field)
+			fields = append(fields, flatField{Slot: index, Field: field})
+		}
+	}
+
+	ret := make([][]string, len(rows))
+	for i, row := range rows {
+		ret[i] = make([]string, len(fields))
+		for j, field := range fields {
+			if entity, ok := row[field.Slot]; ok {
+				ret[i][j] = entity.GetFieldByString(field.Field)
+			}
+		}
+	}
+	return columns, ret
+}
+
+// returns the rows of the given subqueries of the entity. the
+// relations of each subquery are taken from the relations of the
+// entity in the order they were added. slot is the index of the
+// first subquery
+func flattenJoins(queries []Query, entity transport.TransportEntity, joins *flatJoins, slot int) []map[int]transport.TransportEntity {
+	rows := []map[int]transport.TransportEntity{{}}
+	if nil == joins {
+		return rows
+	}
+	childOffset, parentOffset := 0, 0
+	for key, subQuery := range queries {
+		if !isFlatSlot(subQuery) {
+			continue
+		}
+		relations, offset := entity.ParentRelations, &parentOffset
+		if DIRECTION_CHILD == subQuery.Direction {
+			relations, offset = entity.ChildRelations, &childOffset
+		}
+		block := relations[*offset : *offset+joins.amounts[key]]
+		*offset += joins.amounts[key]
+
+		var subRows []map[int]transport.TransportEntity
+		for _, relation := range block {
+			for _, nestedRow := range flattenJoins(subQuery.Map, relation.Target, joins.nested[key][getFlatKey(relation.Target)], slot+1) {
+				nestedRow[slot] = relation.Target
+				subRows = append(subRows, nestedRow)
+			}
+		}
+		slot += getFlatSlotCount(subQuery)
+		if 0 == len(subRows) {
+			continue
+		}
+
+		// combine the rows with the ones of the previous subqueries
+		var combined []map[int]transport.TransportEntity
+		for _, row := range rows {
+			for _, subRow := range subRows {
+				merged := make(map[int]transport.TransportEntity, len(row)+len(subRow))
+				for index, entity := range row {
+					merged[index] = entity
+				}
+				for index, entity := range subRow {
+					merged[index] = entity
+				}
+				combined = append(combined, merged)
+			}
+		}
+		rows = combined
+	}
+	return rows
+}
+
+// identifies an entity within the joins of a flat result
+func getFlatKey(entity transport.TransportEntity) string {
+	return entity.Type + ":" + strconv.Itoa(entity.ID)
+}
+
+// returns the fields of the columns of a slot. without selection
+// all base fields and every property found in the rows are used
+func getFlatFields(selection []string, rows []map[int]transport.TransportEntity, slot int) []string {
+	ret := []string{"Type", "ID"}
+	allProperties := 0 == len(selection)
+	if allProperties {
+		ret = append(ret, "Value", "Context")
+	}
+	properties := make(map[string]bool)
+	for _, field := range selection {
+		switch field {
+		case "Value", "Context":
+			if !containsString(ret, field) {
+				ret = append(ret, field)
+			}
+		case "Properties":
+			allProperties = true
+		default:
+			if strings.HasPrefix(field, "Properties.") {
+				properties[field[11:]] = true
+			}
+		}
+	}
+	ret = append(ret, "Version")
+	if allProperties {
+		for _, row := range rows {
+			if entity, ok := row[slot]; ok {
+				for key := range entity.Properties {
+					properties[key] = true
+				}
+			}
+		}
+	}
+	var keys []string
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		ret = append(ret, "Properties."+key)
+	}
+	return ret
+}
+
+func containsString(list []string, value string) bool {
+	for _, entry := range list {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package query
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/voodooEntity/gits/src/storage"
+	"github.com/voodooEntity/gits/src/transport"
+)
+
+// position of an entity in the order of a paged result. entities
+// with equal values are ordered by their type and id so every
+// entity has a fixed position
+type pageKey struct {
+	Values []string
+	Type   string
+	ID     int
+}
+
+// returns the page of the addresses matching the offset, cursor and
+// limit of the query and the cursor of the next page. only the
+// ordered fields of the entities are read so nothing else needs
+// to be copied for entities outside of the page
+func pageAddresses(store *storage.Storage, addresses [][2]int, qry Query) ([][2]int, string) {
+	orders := qry.getOrders()
+	keys := make([]pageKey, len(addresses))
+	for i, address := range addresses {
+		keys[i].Type, _ = store.GetTypeStringByIdUnsafe(address[0])
+		keys[i].ID = address[1]
+		keys[i].Values = make([]string, len(orders))
+		for j, order := range orders {
+			keys[i].Values[j], _ = store.GetEntityFieldByAddressUnsafe(address, order.Field)
+		}
+	}
+	page, next := getPage(keys, qry)
+	ret := make([][2]int, len(page))
+	for i, index := range page {
+		ret[i] = addresses[index]
+	}
+	return ret, getNextCursor(keys, page, next)
+}
+
+// returns the page of the entities matching the offset, cursor and
+// limit of the query and the cursor of the next page
+func pageResults(entities []transport.TransportEntity, qry Query) ([]transport.TransportEntity, string) {
+	keys := make([]pageKey, len(entities))
+	for i, entity := range entities {
+		keys[i] = getPageKey(entity, qry.getOrders())
+	}
+	page, next := getPage(keys, qry)
+	ret := make([]transport.TransportEntity, len(page))
+	for i, index := range page {
+		ret[i] = entities[index]
+	}
+	return ret, getNextCursor(keys, page, next)
+}
+
+// sorts the keys and returns the indexes of the keys on
+// the page and if there is a next page
+func getPage(keys []pageKey, qry Query) ([]int, bool) {
+	orders := qry.getOrders()
+	order := getSortedIndexes(keys, orders)
+
+	start := 0
+	if cursor, ok := getAfterIfExists(qry); ok {
+		start = sort.Search(len(order), func(i int) bool {
+			return 0 < comparePageKeys(keys[order[i]], cursor, orders)
+		})
+	}
+	start += getOffsetIfExists(qry)
+	if start > len(order) {
+		start = len(order)
+	}
+	end := len(order)
+	if limit := getLimitIfExists(qry); 0 <= limit && start+limit < end {
+		end = start + limit
+	}
+	return order[start:end], end < len(order)
+}
+
+// returns the indexes of the keys sorted by all orders,
+// equal keys are sorted by their type and id
+func getSortedIndexes(keys []pageKey, orders []Order) []int {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return 0 > comparePageKeys(keys[order[i]], keys[order[j]], orders)
+	})
+	return order
+}
+
+// returns the cursor of the last key on the page
+// if there is a next page
+func getNextCursor(keys []pageKey, page []int, next bool) string {
+	if !next || 0 == len(page) {
+		return ""
+	}
+	return encodeCursor(keys[page[len(page)-1]])
+}
+
+func getPageKey(entity transport.TransportEntity, orders []Order) pageKey {
+	key := pageKey{
+		Type:   entity.Type,
+		ID:     entity.ID,
+		Values: make([]string, len(orders)),
+	}
+	for i, order := range orders {
+		key.Values[i] = entity.GetFieldByString(order.Field)
+	}
+	return key
+}
+
+// compares the keys by the values of all orders, equal
+// keys are compared by type and id
+func comparePageKeys(alpha pageKey, beta pageKey, orders []Order) int {
+	for i, order := range orders {
+		if i >= len(alpha.Values) || i >= len(beta.Values) {
+			break
+		}
+		if ret := compareOrderValues(alpha.Values[i], beta.Values[i], order.Mode); 0 != ret {
+			if ORDER_DIRECTION_DESC == order.Direction {
+				return -ret
+			}
+			return ret
+		}
+	}
+	if alpha.Type != beta.Type {
+		return strings.Compare(alpha.Type, beta.Type)
+	}
+	if alpha.ID != beta.ID {
+		if alpha.ID < beta.ID {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// compares two values of an ordered field. in numeric mode
+// numbers are ordered before anything else
+func compareOrderValues(alpha string, beta string, mode int) int {
+	if ORDER_MODE_NUM == mode {
+		iAlpha, erra := strconv.ParseInt(alpha, 10, 64)
+		iBeta, errb := strconv.ParseInt(beta, 10, 64)
+		if nil == erra && nil == errb {
+			if iAlpha < iBeta {
+				return -1
+			}
+			if iAlpha > iBeta {
+				return 1
+			}
+			return 0
+		}
+		if nil == erra {
+			return -1
+		}
+		if nil == errb {
+			return 1
+		}
+		return strings.Compare(alpha, beta)
+	}
+	if ret := strings.Compare(strings.ToLower(alpha), strings.ToLower(beta)); 0 != ret {
+		return ret
+	}
+	return strings.Compare(alpha, beta)
+}
+
+func encodeCursor(key pageKey) string {
+	data, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (pageKey, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if nil != err {
+		return pageKey{}, false
+	}
+	var key pageKey
+	if nil != json.Unmarshal(data, &key) {
+		return pageKey{}, false
+	}
+	return key, true
+}
+
+// reads with a limit, offset or cursor are paged
+func isPaged(qry Query) bool {
+	if nil != qry.Mode {
+		for _, mode := range qry.Mode {
+			if 0 < len(mode) && ("Limit" == mode[0] || "Offset" == mode[0] || "After" == mode[0]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func getOffsetIfExists(qry Query) int {
+	if nil != qry.Mode {
+		for _, mode := range qry.Mode {
+			if 2 == len(mode) && "Offset" == mode[0] {
+				offset, err := strconv.Atoi(mode[1])
+				if nil != err || 0 > offset {
+					return 0
+				}
+				return offset
+			}
+		}
+	}
+	return 0
+}
+
+func getAfterIfExists(qry Query) (pageKey, bool) {
+	if nil != qry.Mode {
+		for _, mode := range qry.Mode {
+			if 2 == len(mode) && "After" == mode[0] {
+				return decodeCursor(mode[1])
+			}
+		}
+	}
+	return pageKey{}, false
+}
@@ -5,6 +5,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/voodooEntity/gits/src/storage"
 
@@ -47,13 +49,18 @@ type Query struct {
 	Method             int
 	Pool               []string
 	Conditions         [][][3]string
+	Filter             []Condition
 	Map                []Query
 	Mode               [][]string
 	Values             map[string]string
+	Operations         [][]string
 	currConditionGroup int
 	Sort               Order
+	Sorts              []Order
 	Direction          int
 	Required           bool
+	Negated            bool
+	Alias              string
 }
 
 type Order struct {
@@ -186,6 +193,40 @@ func (self *Query) CanFrom(query *Query) *Query {
 	return self
 }
 
+// only keeps entities without any child matching the query
+func (self *Query) NotTo(query *Query) *Query {
+	query.setDirection(DIRECTION_CHILD)
+	query.Required = false
+	query.Negated = true
+	self.Map = append(self.Map, *query)
+	return self
+}
+
+// only keeps entities without any parent matching the query
+func (self *Query) NotFrom(query *Query) *Query {
+	query.setDirection(DIRECTION_PARENT)
+	query.Required = false
+	query.Negated = true
+	self.Map = append(self.Map, *query)
+	return self
+}
+
+// joins entities reachable within minHops to maxHops relations
+// towards the children. if via types are given all entities
+// in between have to be of one of those types
+func (self *Query) ToPath(query *Query, minHops int, maxHops int, via ...string) *Query {
+	query.setHops(minHops, maxHops, via)
+	return self.To(query)
+}
+
+// joins entities reachable within minHops to maxHops relations
+// towards the parents. if via types are given all entities
+// in between have to be of one of those types
+func (self *Query) FromPath(query *Query, minHops int, maxHops int, via ...string) *Query {
+	query.setHops(minHops, maxHops, via)
+	return self.From(query)
+}
+
 // ### deprecated
 func (self *Query) Modify(properties ...string) *Query {
 	self.Mode = append(self.Mode, properties)
@@ -197,20 +238,87 @@ func (self *Query) setDirection(direction int) *Query {
 	return self
 }
 
+func (self *Query) setHops(minHops int, maxHops int, via []string) *Query {
+	self.Mode = append(self.Mode, append([]string{"Hops", strconv.Itoa(minHops), strconv.Itoa(maxHops)}, via...))
+	return self
+}
+
 func (self *Query) Set(key string, value string) *Query {
 	self.Values[key] = value
 	return self
 }
 
+// adds delta to the numeric value of the field, empty
+// fields count as 0
+func (self *Query) Increment(field string, delta int) *Query {
+	self.Operations = append(self.Operations, []string{storage.UPDATE_INCREMENT, field, strconv.Itoa(delta)})
+	return self
+}
+
+// appends the suffix to the current value of the field, missing
+// fields are treated as empty
+func (self *Query) Append(field string, suffix string) *Query {
+	self.Operations = append(self.Operations, []string{storage.UPDATE_APPEND, field, suffix})
+	return self
+}
+
+// only sets the field if it is empty or doesnt exist
+func (self *Query) SetIfEmpty(field string, value string) *Query {
+	self.Operations = append(self.Operations, []string{storage.UPDATE_SET_IF_EMPTY, field, value})
+	return self
+}
+
+// only sets the field if it currently equals expected. entities
+// with another value are reported as failed and left unchanged
+func (self *Query) CompareAndSet(field string, expected string, value string) *Query {
+	self.Operations = append(self.Operations, []string{storage.UPDATE_COMPARE_AND_SET, field, expected, value})
+	return self
+}
+
+// removes a property or empties value or context when updating
+func (self *Query) Unset(field string) *Query {
+	self.Operations = append(self.Operations, []string{storage.UPDATE_UNSET, field})
+	return self
+}
+
+// removes all properties when updating
+func (self *Query) ClearProperties() *Query {
+	return self.Unset("Properties")
+}
+
 func (self *Query) Order(field string, direction int, mode int) *Query {
 	self.Sort = Order{
 		Direction: direction,
 		Mode:      mode,
 		Field:     field,
 	}
+	self.Sorts = nil
 	return self
 }
 
+// adds an order, entities with equal values in all
+// previous orders are sorted by this one
+func (self *Query) OrderBy(field string, direction int, mode int) *Query {
+	if (Order{}) == self.Sort {
+		return self.Order(field, direction, mode)
+	}
+	self.Sorts = append(self.Sorts, Order{
+		Direction: direction,
+		Mode:      mode,
+		Field:     field,
+	})
+	return self
+}
+
+// returns all orders of the query, Sort
+// followed by the ones in Sorts
+func (self *Query) getOrders() []Order {
+	if (Order{}) == self.Sort {
+		return nil
+	}
+	return append([]Order{self.Sort}, self.Sorts...)
+}
+
 func (self *Query) TraverseOut(depth int) *Query {
 	self.Mode = append(self.Mode, []string{"Traverse", strconv.Itoa(DIRECTION_CHILD), strconv.Itoa(depth)})
 	return self
@@ -231,6 +339,88 @@ func (self *Query) CascadeIn(depth int) *Query {
 	return self
 }
 
+// reads the data in the state it had at the given time, only
+// applies to Read root queries. the query is executed on a copy of
+// the storage in that state, so every read copies all entities and
+// relations including the recorded history
+func (self *Query) AsOf(t time.Time) *Query {
+	self.Mode = append(self.Mode, []string{"AsOf", "time", t.Format(time.RFC3339Nano)})
+	return self
+}
+
+// returns the matched entities in the state of the given version.
+// conditions and joins are applied on the current state, sorting and
+// paging on the state of the version. entities without the version
+// are left out. only applies to Read root queries
+func (self *Query) AsOfVersion(version int) *Query {
+	self.Mode = append(self.Mode, []string{"AsOf", "version", strconv.Itoa(version)})
+	return self
+}
+
+// only updates entities whose current version matches the given
+// one, entities with another version are reported as failed
+func (self *Query) IfVersion(version int) *Query {
+	self.Mode = append(self.Mode, []string{"IfVersion", strconv.Itoa(version)})
+	return self
+}
+
+// expects a single entity to have the given version. overrides
+// the version given by IfVersion for this entity
+func (self *Query) IfEntityVersion(etype string, id int, version int) *Query {
+	self.Mode = append(self.Mode, []string{"IfVersion", strconv.Itoa(version), etype, strconv.Itoa(id)})
+	return self
+}
+
+// reports what an Update, Delete, Link or Unlink query would
+// change without changing anything
+func (self *Query) DryRun() *Query {
+	self.Mode = append(self.Mode, []string{"DryRun"})
+	return self
+}
+
+// makes Update return the updated entities and Delete the
+// deleted entities and relations
+func (self *Query) Returning() *Query {
+	self.Mode = append(self.Mode, []string{"Returning"})
+	return self
+}
+
+// only returns the given fields ("Value", "Context", "Properties"
+// or "Properties.name") of the read entities. type, id and version
+// are always returned
+func (self *Query) Select(fields ...string) *Query {
+	self.Mode = append(self.Mode, append([]string{"Select"}, fields...))
+	return self
+}
+
+// names the query, the alias is used to name the
+// columns of flat results
+func (self *Query) As(alias string) *Query {
+	self.Alias = alias
+	return self
+}
+
+// additionally returns a Read result as table with one row per
+// path through the joined entities in the Columns and Rows fields
+func (self *Query) Flat() *Query {
+	self.Mode = append(self.Mode, []string{"Flat"})
+	return self
+}
+
+// skips the given amount of entities of a Read result
+func (self *Query) Offset(amount int) *Query {
+	self.Mode = append(self.Mode, []string{"Offset", strconv.Itoa(amount)})
+	return self
+}
+
+// continues a Read result after the entity the cursor points to.
+// the cursor of the next page is returned in the Cursor field
+// of paged results, an empty cursor starts at the first page
+func (self *Query) After(cursor string) *Query {
+	self.Mode = append(self.Mode, []string{"After", cursor})
+	return self
+}
+
 func (self *Query) Limit(amount int) *Query {
 	self.Mode = append(self.Mode, []string{"Limit", strconv.Itoa(amount)})
 	return self
@@ -240,9 +430,27 @@ func Execute(store *storage.Storage, query *Query) transport.Transport {
 	if 0 == len(query.Pool) {
 		return transport.Transport{}
 	}
+	if err := query.Validate(); nil != err {
+		return transport.Transport{Failed: []transport.TransportFailure{{Error: err.Error()}}}
+	}
+	// links are created to the matches of the subqueries
+	// so there is nothing to link for negated ones
+	if METHOD_LINK == query.Method && hasNegatedSubQueries(*query) {
+		return transport.Transport{}
+	}
 
-	mutexh := mutexhandler.New(store)
+	// reads at a time in the past are executed on a snapshot of the storage
 	if METHOD_READ == query.Method {
+		if snapshot, ok := getAsOfSnapshot(store, *query); ok {
+			store = snapshot
+		}
+	}
+
+	// dry runs only need to read
+	dryRun := isDryRun(*query)
+
+	mutexh := mutexhandler.New(store)
+	if METHOD_READ == query.Method || dryRun {
 		mutexh.Apply(mutexhandler.EntityTypeRLock)
 		mutexh.Apply(mutexhandler.EntityStorageRLock)
 	} else {
@@ -250,8 +458,9 @@ func Execute(store *storage.Storage, query *Query) transport.Transport {
 		mutexh.Apply(mutexhandler.EntityStorageLock)
 	}
 
-	if 0 < len(query.Map) {
-		if METHOD_LINK == query.Method || METHOD_UNLINK == query.Method {
+	// deleting entities deletes their relations too
+	if 0 < len(query.Map) || METHOD_DELETE == query.Method {
+		if (METHOD_LINK == query.Method || METHOD_UNLINK == query.Method || METHOD_DELETE == query.Method) && !dryRun {
 			mutexh.Apply(mutexhandler.RelationStorageLock)
 		} else {
 			mutexh.Apply(mutexhandler.RelationStorageRLock)
@@ -270,9 +479,33 @@ func Execute(store *storage.Storage, query *Query) transport.Transport {
 	if METHOD_LINK == query.Method {
 		linked = false
 	}
+	returning := isReturning(*query)
+
+	conditions, tree := getConditions(*query)
+	baseMatchList, propertyMatchList := parseConditions(conditions)
+
+	// paged reads without joins only copy the entities on the page
+	if METHOD_READ == query.Method && 0 == len(query.Map) && isPaged(*query) && !isAsOfVersion(*query) {
+		_, addresses, _ := store.GetEntitiesByQueryFilterTree(query.Pool, conditions, baseMatchList[FILTER_ID], baseMatchList[FILTER_VALUE], baseMatchList[FILTER_CONTEXT], propertyMatchList, tree, false)
+		page, cursor := pageAddresses(store, addresses, *query)
+		ret := transport.Transport{
+			Entities: store.GetEntitiesByAddressListUnsafe(page, getSelection(*query)...),
+			Amount:   len(page),
+			Cursor:   cursor,
+		}
+		if direction, depth, traversed := isTraversed(*query); traversed {
+			for id := range ret.Entities {
+				store.TraverseEnrich(&(ret.Entities[id]), direction, depth)
+			}
+		}
+		if isFlat(*query) {
+			ret.Columns, ret.Rows = flattenResults(*query, ret.Entities, nil)
+		}
+		mutexh.Release()
+		return ret
+	}
 
-	baseMatchList, propertyMatchList := parseConditions(query)
-	initialResultData, initialResultAddresses, initialAmount := store.GetEntitiesByQueryFilter(query.Pool, query.Conditions, baseMatchList[FILTER_ID], baseMatchList[FILTER_VALUE], baseMatchList[FILTER_CONTEXT], propertyMatchList, returnDataFlag)
+	initialResultData, initialResultAddresses, initialAmount := store.GetEntitiesByQueryFilterTree(query.Pool, conditions, baseMatchList[FILTER_ID], baseMatchList[FILTER_VALUE], baseMatchList[FILTER_CONTEXT], propertyMatchList, tree, returnDataFlag, getSelection(*query)...)
 
 	ret := transport.Transport{
 		Amount: 0,
@@ -286,11 +519,21 @@ func Execute(store *storage.Storage, query *Query) transport.Transport {
 	var finalFilteredAddresses [][2]int
 	var tempEntitiesForRead []transport.TransportEntity
 
+	// flat results are built from the joins recorded per root entity
+	var rootJoins map[string]*flatJoins
+	if METHOD_READ == query.Method && isFlat(*query) {
+		rootJoins = make(map[string]*flatJoins)
+	}
+
 	if 0 < len(query.Map) {
 		if linked { // Path for Read-with-joins, Update, Delete, Unlink
 			collectAddressPairs := [][4]int{}
 			for key, entityAddress := range initialResultAddresses {
-				childrenFromSubquery, parentsFromSubquery, tmpAddressPairsFromSub, subAmount := recursiveExecuteLinked(store, query.Map, entityAddress)
+				var entityJoins *flatJoins
+				if nil != rootJoins {
+					entityJoins = &flatJoins{}
+				}
+				childrenFromSubquery, parentsFromSubquery, tmpAddressPairsFromSub, subAmount := recursiveExecuteLinked(store, query.Map, entityAddress, bindAlias(nil, query.Alias, entityAddress), entityJoins)
 
 				if query.HasRequiredSubQueries() && subAmount == 0 {
 					continue
@@ -311,6 +554,9 @@ func Execute(store *storage.Storage, query *Query) transport.Transport {
 						currentEntityDataForRead.ParentRelations = append(currentEntityDataForRead.ParentRelations, parentsFromSubquery...)
 					}
 					tempEntitiesForRead = append(tempEntitiesForRead, currentEntityDataForRead)
+					if nil != entityJoins {
+						rootJoins[getFlatKey(currentEntityDataForRead)] = entityJoins
+					}
 				}
 			}
 			if query.Method == METHOD_UNLINK {
@@ -324,8 +570,9 @@ func Execute(store *storage.Storage, query *Query) transport.Transport {
 		} else { // Path for METHOD_LINK (linked = false)
 			finalFilteredAddresses = initialResultAddresses
 			for _, targetQuery := range query.Map {
-				tagretBaseMatchList, targetPopertyMatchList := parseConditions(&targetQuery)
-				_, tmpLinkAddresses, tmpLinkAmount := store.GetEntitiesByQueryFilter(targetQuery.Pool, targetQuery.Conditions, tagretBaseMatchList[FILTER_ID], tagretBaseMatchList[FILTER_VALUE], tagretBaseMatchList[FILTER_CONTEXT], targetPopertyMatchList, false)
+				targetConditions, targetTree := getConditions(targetQuery)
+				tagretBaseMatchList, targetPopertyMatchList := parseConditions(targetConditions)
+				_, tmpLinkAddresses, tmpLinkAmount := store.GetEntitiesByQueryFilterTree(targetQuery.Pool, targetConditions, tagretBaseMatchList[FILTER_ID], tagretBaseMatchList[FILTER_VALUE], tagretBaseMatchList[FILTER_CONTEXT], targetPopertyMatchList, targetTree, false)
 				if 0 < tmpLinkAmount {
 					linkAddresses[targetQuery.Direction] = append(linkAddresses[targetQuery.Direction], tmpLinkAddresses...)
 					linkAmount = linkAmount + tmpLinkAmount
@@ -364,10 +611,28 @@ func Execute(store *storage.Storage, query *Query) transport.Transport {
 
 	switch query.Method {
 	case METHOD_UPDATE:
-		if 0 < len(query.Values) && len(finalFilteredAddresses) > 0 {
-			store.BatchUpdateAddressList(finalFilteredAddresses, query.Values)
-		}
 		ret.Amount = len(finalFilteredAddresses) // Ensure Amount reflects actual items considered for update
+		if dryRun {
+			if 0 < len(query.Values) || 0 < len(query.Operations) {
+				preview, failed := store.PreviewModifyAddressListUnsafe(finalFilteredAddresses, query.Values, query.Operations, getExpectedVersions(store, *query, finalFilteredAddresses))
+				ret.Entities = preview
+				ret.Failed = getFailures(store, finalFilteredAddresses, failed)
+				ret.Amount -= len(ret.Failed)
+			}
+		} else if (0 < len(query.Values) || 0 < len(query.Operations)) && len(finalFilteredAddresses) > 0 {
+			failed := store.BatchModifyAddressList(finalFilteredAddresses, query.Values, query.Operations, getExpectedVersions(store, *query, finalFilteredAddresses))
+			ret.Failed = getFailures(store, finalFilteredAddresses, failed)
+			ret.Amount -= len(ret.Failed)
+			if returning {
+				var updated [][2]int
+				for _, address := range finalFilteredAddresses {
+					if _, ok := failed[address]; !ok {
+						updated = append(updated, address)
+					}
+				}
+				ret.Entities = store.GetEntitiesByAddressListUnsafe(updated, getSelection(*query)...)
+			}
+		}
 	case METHOD_DELETE:
 		if len(finalFilteredAddresses) == 0 {
 			return transport.Transport{}
@@ -387,19 +652,37 @@ func Execute(store *storage.Storage, query *Query) transport.Transport {
 			for addr := range entitiesToDelete {
 				addressListForBatchDelete = append(addressListForBatchDelete, addr)
 			}
+			sort.Slice(addressListForBatchDelete, func(i, j int) bool {
+				if addressListForBatchDelete[i][0] != addressListForBatchDelete[j][0] {
+					return addressListForBatchDelete[i][0] < addressListForBatchDelete[j][0]
+				}
+				return addressListForBatchDelete[i][1] < addressListForBatchDelete[j][1]
+			})
 
-			store.BatchDeleteAddressList(addressListForBatchDelete)
-			ret.Amount = len(addressListForBatchDelete)
-		} else {
+			finalFilteredAddresses = addressListForBatchDelete
+		}
+
+		if returning || dryRun {
+			ret.Entities = store.GetEntitiesByAddressListUnsafe(finalFilteredAddresses, getSelection(*query)...)
+			ret.Relations = store.GetRelationsByAddressListUnsafe(finalFilteredAddresses)
+		}
+		if !dryRun {
 			store.BatchDeleteAddressList(finalFilteredAddresses)
-			ret.Amount = len(finalFilteredAddresses)
 		}
+		ret.Amount = len(finalFilteredAddresses)
 	case METHOD_LINK:
 		affectedAmount := 0
 		if 0 < linkAmount && len(finalFilteredAddresses) > 0 {
 			for direction, currentTargetAddresses := range linkAddresses {
 				if 0 < len(currentTargetAddresses) {
-					if DIRECTION_CHILD == direction {
+					if dryRun {
+						if DIRECTION_CHILD == direction {
+							ret.Relations = appendRelations(ret.Relations, store.PreviewLinkAddressListsUnsafe(finalFilteredAddresses, currentTargetAddresses))
+						} else {
+							ret.Relations = appendRelations(ret.Relations, store.PreviewLinkAddressListsUnsafe(currentTargetAddresses, finalFilteredAddresses))
+						}
+						affectedAmount = len(ret.Relations)
+					} else if DIRECTION_CHILD == direction {
 						affectedAmount += store.LinkAddressLists(finalFilteredAddresses, currentTargetAddresses)
 					} else {
 						affectedAmount += store.LinkAddressLists(currentTargetAddresses, finalFilteredAddresses)
@@ -410,7 +693,10 @@ func Execute(store *storage.Storage, query *Query) transport.Transport {
 		ret.Amount = affectedAmount
 	case METHOD_UNLINK:
 		affectedAmount := 0
-		if 0 < len(addressPairs) {
+		if dryRun {
+			ret.Relations = store.GetRelationsByRelationAddressListUnsafe(addressPairs)
+			affectedAmount = len(addressPairs)
+		} else if 0 < len(addressPairs) {
 			for _, addressPair := range addressPairs {
 				store.DeleteRelationUnsafe(addressPair[0], addressPair[1], addressPair[2], addressPair[3])
 				affectedAmount++
@@ -425,9 +711,18 @@ func Execute(store *storage.Storage, query *Query) transport.Transport {
 		}
 	}
 
-	if METHOD_READ == query.Method || ((query.Method == METHOD_UPDATE || query.Method == METHOD_DELETE) && returnDataFlag) {
-		if (Order{}) != query.Sort {
-			ret.Entities = sortResults(ret.Entities, query.Sort.Field, query.Sort.Direction, query.Sort.Mode)
+	// sorting and paging of version reads use the values of the version
+	if METHOD_READ == query.Method && isAsOfVersion(*query) {
+		ret.Entities = getEntitiesAtVersion(store, *query, ret.Entities)
+		ret.Amount = len(ret.Entities)
+	}
+
+	if METHOD_READ == query.Method && isPaged(*query) {
+		ret.Entities, ret.Cursor = pageResults(ret.Entities, *query)
+		ret.Amount = len(ret.Entities)
+	} else if METHOD_READ == query.Method || ((query.Method == METHOD_UPDATE || query.Method == METHOD_DELETE) && (returning || dryRun)) {
+		if orders := query.getOrders(); 0 < len(orders) {
+			ret.Entities = sortResults(ret.Entities, orders)
 		}
 		limit := getLimitIfExists(*query)
 		if -1 != limit {
@@ -440,27 +735,123 @@ func Execute(store *storage.Storage, query *Query) transport.Transport {
 		}
 	}
 
+	if METHOD_READ == query.Method && isFlat(*query) {
+		ret.Columns, ret.Rows = flattenResults(*query, ret.Entities, rootJoins)
+	}
+
+	mutexh.Release()
+	return ret
+}
+
+// enumerates all simple paths leading from the entities matched by the
+// from query to the entities matched by the to query. joins inside
+// of both queries are applied as filters. the paths are returned in
+// the Paths field of the result ordered by their length
+func ExecutePaths(store *storage.Storage, from *Query, to *Query, opts storage.PathOptions) (transport.Transport, error) {
+	for _, qry := range []*Query{from, to} {
+		if err := qry.Validate(); nil != err {
+			return transport.Transport{}, err
+		}
+	}
+	if err := opts.Validate(); nil != err {
+		return transport.Transport{}, err
+	}
+	mutexh := mutexhandler.New(store)
+	mutexh.Apply(mutexhandler.EntityTypeRLock)
+	mutexh.Apply(mutexhandler.EntityStorageRLock)
+	mutexh.Apply(mutexhandler.RelationStorageRLock)
+
+	fromAddresses := getFilteredAddresses(store, from)
+	toAddresses := getFilteredAddresses(store, to)
+	if 0 == len(fromAddresses) || 0 == len(toAddresses) {
+		mutexh.Release()
+		return transport.Transport{}, nil
+	}
+	ret, err := store.FindPathsUnsafe(fromAddresses, toAddresses, opts)
+
 	mutexh.Release()
+	return ret, err
+}
+
+// copies the entities matching the query and everything within depth
+// relations around them into a new storage, see storage.ExtractSubgraph
+func ExtractSubgraph(store *storage.Storage, query *Query, depth int, remapIDs bool) (*storage.Storage, map[[2]int][2]int) {
+	mutexh := mutexhandler.New(store)
+	mutexh.Apply(mutexhandler.EntityTypeRLock)
+	mutexh.Apply(mutexhandler.EntityStorageRLock)
+	mutexh.Apply(mutexhandler.RelationStorageRLock)
+
+	ret, addressMap := store.ExtractSubgraphUnsafe(getFilteredAddresses(store, query), depth, remapIDs)
+
+	mutexh.Release()
+	return ret, addressMap
+}
+
+// returns the addresses of all entities matching the given query
+// including its required joins. expects the storage to be locked
+func getFilteredAddresses(store *storage.Storage, query *Query) [][2]int {
+	conditions, tree := getConditions(*query)
+	baseMatchList, propertyMatchList := parseConditions(conditions)
+	_, addresses, amount := store.GetEntitiesByQueryFilterTree(query.Pool, conditions, baseMatchList[FILTER_ID], baseMatchList[FILTER_VALUE], baseMatchList[FILTER_CONTEXT], propertyMatchList, tree, false)
+	if 0 == amount || 0 == len(query.Map) {
+		return addresses
+	}
+
+	var ret [][2]int
+	for _, address := range addresses {
+		_, _, _, subAmount := recursiveExecuteLinked(store, query.Map, address, bindAlias(nil, query.Alias, address), nil)
+		if query.HasRequiredSubQueries() && 0 == subAmount {
+			continue
+		}
+		ret = append(ret, address)
+	}
 	return ret
 }
 
-func recursiveExecuteLinked(store *storage.Storage, queries []Query, sourceAddress [2]int) ([]transport.TransportRelation, []transport.TransportRelation, [][4]int, int) {
+// executes the subqueries for the given source entity. bindings hold
+// the addresses of the entities matched by aliased parent queries
+// which can be referenced in conditions. if joins is given the
+// relations added per read subquery are recorded for flat results
+func recursiveExecuteLinked(store *storage.Storage, queries []Query, sourceAddress [2]int, bindings map[string][2]int, joins *flatJoins) ([]transport.TransportRelation, []transport.TransportRelation, [][4]int, int) {
 	var retParents []transport.TransportRelation
 	var retChildren []transport.TransportRelation
 	var collectedAddressPairsForUnlink [][4]int // Pairs formed at this level of recursion
 
 	overallSuccessfulPathsForThisLevel := 0
+	if nil != joins {
+		joins.amounts = make([]int, len(queries))
+		joins.nested = make([]map[string]*flatJoins, len(queries))
+	}
 
-	for _, currentSubQuery := range queries {
+	for queryKey, currentSubQuery := range queries {
 		var fullyProcessedSubRelationsForCurrentQuery []transport.TransportRelation
-		baseMatchList, propertyMatchList := parseConditions(&currentSubQuery)
+		conditions, tree := getConditions(currentSubQuery)
+		baseMatchList, propertyMatchList := parseConditions(conditions)
+		conditions = resolveConditions(store, conditions, bindings)
 
 		subQueryReturnDataFlag := false
-		if METHOD_READ == currentSubQuery.Method {
+		if METHOD_READ == currentSubQuery.Method && !currentSubQuery.Negated {
 			subQueryReturnDataFlag = true
 		}
 
-		resultSubData, resultSubAddresses, directMatchCount := store.GetEntitiesByQueryFilterAndSourceAddress(currentSubQuery.Pool, currentSubQuery.Conditions, baseMatchList[FILTER_ID], baseMatchList[FILTER_VALUE], baseMatchList[FILTER_CONTEXT], propertyMatchList, sourceAddress, currentSubQuery.Direction, subQueryReturnDataFlag)
+		var resultSubData []transport.TransportRelation
+		var resultSubAddresses [][2]int
+		var directMatchCount int
+		minHops, maxHops, via, hops := getHopsIfExists(currentSubQuery)
+		if hops {
+			resultSubData, resultSubAddresses, directMatchCount = store.GetEntitiesByQueryFilterAndSourceAddressHopsTree(currentSubQuery.Pool, conditions, baseMatchList[FILTER_ID], baseMatchList[FILTER_VALUE], baseMatchList[FILTER_CONTEXT], propertyMatchList, tree, sourceAddress, currentSubQuery.Direction, minHops, maxHops, via, subQueryReturnDataFlag, getSelection(currentSubQuery)...)
+		} else {
+			resultSubData, resultSubAddresses, directMatchCount = store.GetEntitiesByQueryFilterAndSourceAddressTree(currentSubQuery.Pool, conditions, baseMatchList[FILTER_ID], baseMatchList[FILTER_VALUE], baseMatchList[FILTER_CONTEXT], propertyMatchList, tree, sourceAddress, currentSubQuery.Direction, subQueryReturnDataFlag, getSelection(currentSubQuery)...)
+		}
+
+		// negated joins pass if none of the related entities matches
+		if currentSubQuery.Negated {
+			if hasLinkedMatch(store, currentSubQuery, resultSubAddresses, bindings) {
+				return []transport.TransportRelation{}, []transport.TransportRelation{}, [][4]int{}, 0
+			}
+			overallSuccessfulPathsForThisLevel++
+			continue
+		}
 
 		if 0 == directMatchCount {
 			if true == currentSubQuery.Required {
@@ -475,7 +866,11 @@ func recursiveExecuteLinked(store *storage.Storage, queries []Query, sourceAddre
 			for key, relatedEntityAddress := range resultSubAddresses {
 				// Pass empty [][4]int{} for addressPairListFromCaller to nested calls,
 				// as pair collection is per level for Unlink.
-				nestedChildren, nestedParents, _, nestedSubAmount := recursiveExecuteLinked(store, currentSubQuery.Map, relatedEntityAddress)
+				var nestedJoins *flatJoins
+				if nil != joins && subQueryReturnDataFlag {
+					nestedJoins = &flatJoins{}
+				}
+				nestedChildren, nestedParents, _, nestedSubAmount := recursiveExecuteLinked(store, currentSubQuery.Map, relatedEntityAddress, bindAlias(bindings, currentSubQuery.Alias, relatedEntityAddress), nestedJoins)
 
 				if currentSubQuery.HasRequiredSubQueries() && nestedSubAmount == 0 {
 					continue // This relatedEntityAddress failed its own required nested join.
@@ -492,14 +887,16 @@ func recursiveExecuteLinked(store *storage.Storage, queries []Query, sourceAddre
 						currentRelation.Target.ParentRelations = append(currentRelation.Target.ParentRelations, nestedParents...)
 					}
 					fullyProcessedSubRelationsForCurrentQuery = append(fullyProcessedSubRelationsForCurrentQuery, currentRelation)
+					if nil != nestedJoins {
+						if nil == joins.nested[queryKey] {
+							joins.nested[queryKey] = make(map[string]*flatJoins)
+						}
+						joins.nested[queryKey][getFlatKey(currentRelation.Target)] = nestedJoins
+					}
 				}
 				// Collect pairs for Unlink: these are pairs formed by sourceAddress and relatedEntityAddress,
 				// assuming this path (including nested) is valid.
-				if DIRECTION_CHILD == currentSubQuery.Direction {
-					collectedAddressPairsForUnlink = append(collectedAddressPairsForUnlink, [4]int{sourceAddress[0], sourceAddress[1], relatedEntityAddress[0], relatedEntityAddress[1]})
-				} else {
-					collectedAddressPairsForUnlink = append(collectedAddressPairsForUnlink, [4]int{relatedEntityAddress[0], relatedEntityAddress[1], sourceAddress[0], sourceAddress[1]})
-				}
+				collectedAddressPairsForUnlink = appendUnlinkPair(store, collectedAddressPairsForUnlink, sourceAddress, relatedEntityAddress, currentSubQuery.Direction, hops)
 				// Note: nestedAddressPairs are not directly used here, they would have been handled by deeper Unlink if query was structured that way.
 			}
 		} else { // currentSubQuery has no nested children/parents
@@ -508,11 +905,7 @@ func recursiveExecuteLinked(store *storage.Storage, queries []Query, sourceAddre
 				fullyProcessedSubRelationsForCurrentQuery = append(fullyProcessedSubRelationsForCurrentQuery, resultSubData...)
 			}
 			for _, relatedEntityAddress := range resultSubAddresses {
-				if DIRECTION_CHILD == currentSubQuery.Direction {
-					collectedAddressPairsForUnlink = append(collectedAddressPairsForUnlink, [4]int{sourceAddress[0], sourceAddress[1], relatedEntityAddress[0], relatedEntityAddress[1]})
-				} else {
-					collectedAddressPairsForUnlink = append(collectedAddressPairsForUnlink, [4]int{relatedEntityAddress[0], relatedEntityAddress[1], sourceAddress[0], sourceAddress[1]})
-				}
+				collectedAddressPairsForUnlink = appendUnlinkPair(store, collectedAddressPairsForUnlink, sourceAddress, relatedEntityAddress, currentSubQuery.Direction, hops)
 			}
 		}
 
@@ -523,6 +916,10 @@ func recursiveExecuteLinked(store *storage.Storage, queries []Query, sourceAddre
 		overallSuccessfulPathsForThisLevel += successfulPathsThroughCurrentSubQuery
 
 		if subQueryReturnDataFlag && 0 < len(fullyProcessedSubRelationsForCurrentQuery) {
+			fullyProcessedSubRelationsForCurrentQuery = sortRelations(fullyProcessedSubRelationsForCurrentQuery, currentSubQuery)
+			if nil != joins {
+				joins.amounts[queryKey] = len(fullyProcessedSubRelationsForCurrentQuery)
+			}
 			var appender *[]transport.TransportRelation
 			if DIRECTION_CHILD == currentSubQuery.Direction {
 				appender = &retChildren
@@ -541,17 +938,47 @@ func recursiveExecuteLinked(store *storage.Storage, queries []Query, sourceAddre
 	return retChildren, retParents, collectedAddressPairsForUnlink, overallSuccessfulPathsForThisLevel
 }
 
-func parseConditions(query *Query) ([3][][]int, []map[string][]int) {
+// returns if any of the related entities matches the subquery
+// including its required joins
+func hasLinkedMatch(store *storage.Storage, qry Query, addresses [][2]int, bindings map[string][2]int) bool {
+	if !qry.HasRequiredSubQueries() {
+		return 0 < len(addresses)
+	}
+	for _, address := range addresses {
+		_, _, _, amount := recursiveExecuteLinked(store, qry.Map, address, bindAlias(bindings, qry.Alias, address), nil)
+		if 0 < amount {
+			return true
+		}
+	}
+	return false
+}
+
+// adds the relation address between source and related entity to the unlink
+// pairs. entities matched over multiple hops are only added if they
+// are directly related since there is nothing to unlink otherwise
+func appendUnlinkPair(store *storage.Storage, pairs [][4]int, sourceAddress [2]int, relatedAddress [2]int, direction int, hops bool) [][4]int {
+	pair := [4]int{relatedAddress[0], relatedAddress[1], sourceAddress[0], sourceAddress[1]}
+	if DIRECTION_CHILD == direction {
+		pair = [4]int{sourceAddress[0], sourceAddress[1], relatedAddress[0], relatedAddress[1]}
+	}
+	if hops && !store.RelationExistsUnsafe(pair[0], pair[1], pair[2], pair[3]) {
+		return pairs
+	}
+	return append(pairs, pair)
+}
+
+func parseConditions(conditions [][][3]string) ([3][][]int, []map[string][]int) {
 	baseMatchList := [3][][]int{{}, {}, {}}
 	propertyMatchList := []map[string][]int{}
-	for conditionGroupKey, conditionGroup := range query.Conditions {
+	for conditionGroupKey, conditionGroup := range conditions {
 		for _, filterGroup := range [3]int{FILTER_ID, FILTER_VALUE, FILTER_CONTEXT} {
 			baseMatchList[filterGroup] = append(baseMatchList[filterGroup], []int{})
 			baseMatchList[filterGroup][conditionGroupKey] = []int{}
 		}
 		propertyMatchList = append(propertyMatchList, map[string][]int{})
 		for conditionKey, conditionValue := range conditionGroup {
-			switch conditionValue[0] {
+			field, _ := storage.SplitConditionField(conditionValue[0])
+			switch field {
 			case "ID":
 				baseMatchList[FILTER_ID][conditionGroupKey] = append(baseMatchList[FILTER_ID][conditionGroupKey], conditionKey)
 			case "Value":
@@ -559,8 +986,8 @@ func parseConditions(query *Query) ([3][][]int, []map[string][]int) {
 			case "Context":
 				baseMatchList[FILTER_CONTEXT][conditionGroupKey] = append(baseMatchList[FILTER_CONTEXT][conditionGroupKey], conditionKey)
 			default:
-				if -1 != strings.Index(conditionValue[0], "Properties") {
-					propertyName := conditionValue[0][11:]
+				if -1 != strings.Index(field, "Properties") {
+					propertyName := field[11:]
 					if _, ok := propertyMatchList[conditionGroupKey][propertyName]; !ok {
 						propertyMatchList[conditionGroupKey][propertyName] = []int{}
 					}
@@ -572,41 +999,54 @@ func parseConditions(query *Query) ([3][][]int, []map[string][]int) {
 	return baseMatchList, propertyMatchList
 }
 
-func sortResults(results []transport.TransportEntity, field string, direction int, mode int) []transport.TransportEntity {
-	cl := func(i, j int) bool {
-		sAlpha := results[i].GetFieldByString(field)
-		sBeta := results[j].GetFieldByString(field)
+// sorts the entities by all orders, entities with equal
+// values are sorted by their type and id
+func sortResults(results []transport.TransportEntity, orders []Order) []transport.TransportEntity {
+	keys := make([]pageKey, len(results))
+	for i, entity := range results {
+		keys[i] = getPageKey(entity, orders)
+	}
+	order := getSortedIndexes(keys, orders)
+	ret := make([]transport.TransportEntity, len(results))
+	for i, index := range order {
+		ret[i] = results[index]
+	}
+	return ret
+}
 
-		if ORDER_MODE_NUM == mode {
-			iAlpha, erra := strconv.ParseInt(sAlpha, 10, 64)
-			iBeta, errb := strconv.ParseInt(sBeta, 10, 64)
-			if nil == erra && nil == errb {
-				if ORDER_DIRECTION_ASC == direction && iAlpha < iBeta || ORDER_DIRECTION_DESC == direction && iAlpha > iBeta {
-					return true
-				}
-			}
-		} else {
-			sLowerAlpha := strings.ToLower(sAlpha)
-			sLowerBeta := strings.ToLower(sBeta)
-			if sLowerAlpha == sLowerBeta {
-				if ORDER_DIRECTION_ASC == direction && sAlpha < sBeta || ORDER_DIRECTION_DESC == direction && sAlpha > sBeta {
-					return true
-				}
-			} else {
-				if ORDER_DIRECTION_ASC == direction && sLowerAlpha < sLowerBeta || ORDER_DIRECTION_DESC == direction && sLowerAlpha > sLowerBeta {
-					return true
-				}
-			}
+// sorts and limits the relations of a subquery by their targets
+func sortRelations(relations []transport.TransportRelation, qry Query) []transport.TransportRelation {
+	orders := qry.getOrders()
+	if 0 < len(orders) {
+		keys := make([]pageKey, len(relations))
+		for i, relation := range relations {
+			keys[i] = getPageKey(relation.Target, orders)
 		}
-		return false
+		order := getSortedIndexes(keys, orders)
+		sorted := make([]transport.TransportRelation, len(relations))
+		for i, index := range order {
+			sorted[i] = relations[index]
+		}
+		relations = sorted
+	}
+	if limit := getLimitIfExists(qry); -1 != limit && len(relations) > limit {
+		relations = relations[:limit]
 	}
-	sort.Slice(results, cl)
-	return results
+	return relations
 }
 
 func (self *Query) HasRequiredSubQueries() bool {
 	for _, qry := range self.Map {
-		if true == qry.Required {
+		if true == qry.Required || qry.Negated {
+			return true
+		}
+	}
+	return false
+}
+
+func hasNegatedSubQueries(qry Query) bool {
+	for _, subQuery := range qry.Map {
+		if subQuery.Negated {
 			return true
 		}
 	}
@@ -739,6 +1179,283 @@ func collectEntitiesForCascadeDelete(
 	}
 }
 
+func getHopsIfExists(qry Query) (int, int, []string, bool) {
+	if nil != qry.Mode {
+		for _, mode := range qry.Mode {
+			tmpLen := len(mode)
+			if 0 < tmpLen && "Hops" == mode[0] {
+				if 3 <= tmpLen {
+					minHops, err := strconv.Atoi(mode[1])
+					if nil != err {
+						return -1, -1, nil, false
+					}
+					maxHops, err := strconv.Atoi(mode[2])
+					if nil != err {
+						return -1, -1, nil, false
+					}
+					return minHops, maxHops, mode[3:], true
+				}
+			}
+		}
+	}
+	return -1, -1, nil, false
+}
+
+// returns the snapshot of the storage for queries read AsOf a time.
+// the snapshot is a full copy of the storage in that state, its cost
+// grows with the size of the storage and not with the amount of matches
+func getAsOfSnapshot(store *storage.Storage, qry Query) (*storage.Storage, bool) {
+	if nil != qry.Mode {
+		for _, mode := range qry.Mode {
+			if 3 == len(mode) && "AsOf" == mode[0] && "time" == mode[1] {
+				t, err := time.Parse(time.RFC3339Nano, mode[2])
+				if nil != err {
+					return nil, false
+				}
+				return store.SnapshotAsOf(t), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// returns the version given by AsOfVersion
+func getAsOfVersion(qry Query) (int, bool) {
+	if nil != qry.Mode {
+		for _, mode := range qry.Mode {
+			if 3 == len(mode) && "AsOf" == mode[0] && "version" == mode[1] {
+				version, err := strconv.Atoi(mode[2])
+				if nil != err {
+					return 0, false
+				}
+				return version, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func isAsOfVersion(qry Query) bool {
+	_, ok := getAsOfVersion(qry)
+	return ok
+}
+
+// replaces the entities by their state in the version given by
+// AsOfVersion keeping their joins. entities without the version
+// are left out
+func getEntitiesAtVersion(store *storage.Storage, qry Query, entities []transport.TransportEntity) []transport.TransportEntity {
+	version, _ := getAsOfVersion(qry)
+	selection := getSelection(qry)
+	ret := []transport.TransportEntity{}
+	for _, entity := range entities {
+		typeID, err := store.GetTypeIdByStringUnsafe(entity.Type)
+		if nil != err {
+			continue
+		}
+		versioned, err := store.GetTransportEntityAtVersionUnsafe(typeID, entity.ID, version, selection...)
+		if nil != err {
+			continue
+		}
+		versioned.ChildRelations = entity.ChildRelations
+		versioned.ParentRelations = entity.ParentRelations
+		ret = append(ret, versioned)
+	}
+	return ret
+}
+
+// collects the expected version per address from the IfVersion
+// modes of the query. versions given for single entities
+// override the version given for all entities
+func getExpectedVersions(store *storage.Storage, qry Query, addresses [][2]int) map[[2]int]int {
+	versions := make(map[[2]int]int)
+	if nil != qry.Mode {
+		for _, mode := range qry.Mode {
+			if 2 == len(mode) && "IfVersion" == mode[0] {
+				version, err := strconv.Atoi(mode[1])
+				if nil != err {
+					continue
+				}
+				for _, address := range addresses {
+					if _, ok := versions[address]; !ok {
+						versions[address] = version
+					}
+				}
+			}
+		}
+		for _, mode := range qry.Mode {
+			if 4 == len(mode) && "IfVersion" == mode[0] {
+				version, err := strconv.Atoi(mode[1])
+				if nil != err {
+					continue
+				}
+				typeID, err := store.GetTypeIdByStringUnsafe(mode[2])
+				if nil != err {
+					continue
+				}
+				id, err := strconv.Atoi(mode[3])
+				if nil != err {
+					continue
+				}
+				versions[[2]int{typeID, id}] = version
+			}
+		}
+	}
+	return versions
+}
+
+// maps the failed addresses to transport failures in the
+// order of the given address list
+func getFailures(store *storage.Storage, addresses [][2]int, failed map[[2]int]error) []transport.TransportFailure {
+	var ret []transport.TransportFailure
+	if 0 == len(failed) {
+		return ret
+	}
+	for _, address := range addresses {
+		if err, ok := failed[address]; ok {
+			failure := transport.TransportFailure{
+				ID:    address[1],
+				Error: err.Error(),
+			}
+			failure.Type, _ = store.GetTypeStringByIdUnsafe(address[0])
+			if entity, err := store.GetEntityByPathUnsafe(address[0], address[1], ""); nil == err {
+				failure.Version = entity.Version
+			}
+			ret = append(ret, failure)
+		}
+	}
+	return ret
+}
+
+// returns the selected fields of the query. fields used to
+// order the results are added so they can be sorted
+func getSelection(qry Query) []string {
+	var ret []string
+	if nil != qry.Mode {
+		for _, mode := range qry.Mode {
+			if 0 < len(mode) && "Select" == mode[0] {
+				ret = append(ret, mode[1:]...)
+			}
+		}
+	}
+	if 0 < len(ret) {
+		for _, order := range qry.getOrders() {
+			ret = append(ret, order.Field)
+		}
+	}
+	return ret
+}
+
+// returns a copy of the bindings with the address bound to the alias
+func bindAlias(bindings map[string][2]int, alias string, address [2]int) map[string][2]int {
+	if "" == alias {
+		return bindings
+	}
+	ret := make(map[string][2]int, len(bindings)+1)
+	for key, value := range bindings {
+		ret[key] = value
+	}
+	ret[alias] = address
+	return ret
+}
+
+// replaces compare values referencing a field of an aliased parent
+// entity ("$alias.field") by the value of the field. references to
+// unknown aliases or missing fields make the condition fail
+func resolveConditions(store *storage.Storage, conditions [][][3]string, bindings map[string][2]int) [][][3]string {
+	var ret [][][3]string
+	for groupKey, group := range conditions {
+		for conditionKey, condition := range group {
+			alias, field, ok := getAliasReference(condition[2])
+			if !ok {
+				continue
+			}
+			if nil == ret {
+				// copy on first change so the query stays untouched
+				ret = make([][][3]string, len(conditions))
+				for i := range conditions {
+					ret[i] = append([][3]string{}, conditions[i]...)
+				}
+			}
+			value, ok := "", false
+			if address, bound := bindings[alias]; bound {
+				value, ok = store.GetEntityFieldByAddressUnsafe(address, field)
+			}
+			if !ok {
+				// no operator matches an empty one
+				ret[groupKey][conditionKey][1] = ""
+			}
+			ret[groupKey][conditionKey][2] = value
+		}
+	}
+	if nil == ret {
+		return conditions
+	}
+	return ret
+}
+
+// splits a reference "$alias.field" into alias and field. aliases
+// have to start with a letter so values like "$5.00" are no reference
+func getAliasReference(value string) (string, string, bool) {
+	if 3 > len(value) || '$' != value[0] {
+		return "", "", false
+	}
+	separator := strings.Index(value, ".")
+	if 2 > separator || len(value)-1 == separator || !unicode.IsLetter(rune(value[1])) {
+		return "", "", false
+	}
+	return value[1:separator], value[separator+1:], true
+}
+
+func isFlat(qry Query) bool {
+	if nil != qry.Mode {
+		for _, mode := range qry.Mode {
+			if 0 < len(mode) && "Flat" == mode[0] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isReturning(qry Query) bool {
+	if nil != qry.Mode {
+		for _, mode := range qry.Mode {
+			if 0 < len(mode) && "Returning" == mode[0] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isDryRun(qry Query) bool {
+	if nil != qry.Mode {
+		for _, mode := range qry.Mode {
+			if 0 < len(mode) && "DryRun" == mode[0] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// appends relations which are not part of the list yet
+func appendRelations(relations []transport.TransportRelation, add []transport.TransportRelation) []transport.TransportRelation {
+	for _, relation := range add {
+		exists := false
+		for _, existing := range relations {
+			if relation.SourceType == existing.SourceType && relation.SourceID == existing.SourceID && relation.TargetType == existing.TargetType && relation.TargetID == existing.TargetID {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			relations = append(relations, relation)
+		}
+	}
+	return relations
+}
+
 func getLimitIfExists(qry Query) int {
 	if nil != qry.Mode {
 		for _, mode := range qry.Mode {
@@ -1,10 +1,14 @@
 package query
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/voodooEntity/gits/src/storage"
 	"github.com/voodooEntity/gits/src/transport"
@@ -684,7 +688,7 @@ func TestQueryUnlinkReverse(t *testing.T) {
 	if 2 != len(ret.Entities) {
 		t.Error("there should be 2 entries", ret)
 	}
-	if 0 != len(ret.Entities[0].ChildRelations) || 0 != len(ret.Entities[0].ParentRelations) || 0 != len(ret.Entities[0].ChildRelations) || 0 != len(ret.Entities[0].ParentRelations) {
+	if 0 != len(ret.Entities[0].ChildRelations) || 0 != len(ret.Entities[0].ParentRelations) || 0 != len(ret.Entities[1].ChildRelations) || 0 != len(ret.Entities[1].ParentRelations) {
 		t.Error("there are relations that shouldn exist", ret)
 	}
 	t.Cleanup(func() {
@@ -1610,6 +1614,917 @@ func TestNoCascadeEffectWithoutCascadeMode(t *testing.T) {
 	checkEntityExistence(t, "Isolated", "Isolated", true)
 }
 
+func createPathTestData() {
+	testStorage.MapTransportData(transport.TransportEntity{
+		ID:    storage.MAP_FORCE_CREATE,
+		Type:  "Account",
+		Value: "suspicious",
+		ChildRelations: []transport.TransportRelation{
+			{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Device", Value: "phone"}},
+			{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Ip", Value: "10.0.0.1", ChildRelations: []transport.TransportRelation{
+				{Target: transport.TransportEntity{ID: 1, Type: "Device"}},
+			}}},
+		},
+	})
+	testStorage.MapTransportData(transport.TransportEntity{
+		ID:    storage.MAP_FORCE_CREATE,
+		Type:  "Account",
+		Value: "other",
+		ChildRelations: []transport.TransportRelation{
+			{Target: transport.TransportEntity{ID: 1, Type: "Ip"}},
+		},
+	})
+}
+
+func TestExecutePaths(t *testing.T) {
+	initStorage()
+	createPathTestData()
+	defer Cleanup()
+
+	from := New().Find("Account").Match("Value", "==", "suspicious")
+	to := New().Find("Device")
+	ret, err := ExecutePaths(testStorage, from, to, storage.PathOptions{Direction: storage.PATH_CHILD})
+	if nil != err || 2 != ret.Amount || 2 != len(ret.Paths[0].Entities) || 3 != len(ret.Paths[1].Entities) || 2 != len(ret.Paths[1].Relations) || "Ip" != ret.Paths[1].Entities[1].Type {
+		t.Error("expected a direct path and a path over Ip", ret, err)
+	}
+
+	ret, err = ExecutePaths(testStorage, from, to, storage.PathOptions{Direction: storage.PATH_CHILD, MaxPaths: 1})
+	if nil != err || 1 != ret.Amount || 2 != len(ret.Paths[0].Entities) {
+		t.Error("expected only the shortest path", ret, err)
+	}
+
+	from = New().Find("Account").Match("Value", "==", "other")
+	ret, err = ExecutePaths(testStorage, from, to, storage.PathOptions{Direction: storage.PATH_BOTH, MaxDepth: 2})
+	if nil != err || 1 != ret.Amount || "Ip" != ret.Paths[0].Entities[1].Type {
+		t.Error("expected a single path over Ip", ret, err)
+	}
+
+	_, err = ExecutePaths(testStorage, from, to, storage.PathOptions{Direction: storage.PATH_BOTH, MaxExpansions: 1})
+	if nil == err {
+		t.Error("search should have been aborted")
+	}
+}
+
+// creates a folder tree with files in different depths
+// root -> a -> b -> x, root -> y, root -> link -> z
+func createHopTestData() {
+	testStorage.MapTransportData(transport.TransportEntity{
+		ID:    storage.MAP_FORCE_CREATE,
+		Type:  "Folder",
+		Value: "root",
+		ChildRelations: []transport.TransportRelation{
+			{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Folder", Value: "a", ChildRelations: []transport.TransportRelation{
+				{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Folder", Value: "b", ChildRelations: []transport.TransportRelation{
+					{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "File", Value: "x"}},
+				}}},
+			}}},
+			{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "File", Value: "y"}},
+			{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Link", Value: "link", ChildRelations: []transport.TransportRelation{
+				{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "File", Value: "z"}},
+			}}},
+		},
+	})
+}
+
+func TestReadToPath(t *testing.T) {
+	initStorage()
+	createHopTestData()
+	defer Cleanup()
+
+	qry := New().Read("Folder").Match("Value", "==", "root").ToPath(New().Read("File"), 1, 3)
+	ret := Execute(testStorage, qry)
+	if 1 != ret.Amount || 3 != len(ret.Entities[0].ChildRelations) {
+		t.Error("expected all files within 3 hops", ret)
+	}
+
+	qry = New().Read("Folder").Match("Value", "==", "root").ToPath(New().Read("File"), 1, 3, "Folder")
+	ret = Execute(testStorage, qry)
+	if 1 != ret.Amount || 2 != len(ret.Entities[0].ChildRelations) || "x" != ret.Entities[0].ChildRelations[0].Target.Value {
+		t.Error("expected only files reachable over folders", ret)
+	}
+
+	qry = New().Read("Folder").Match("Value", "==", "root").ToPath(New().Read("File"), 2, 2)
+	ret = Execute(testStorage, qry)
+	if 1 != ret.Amount || 1 != len(ret.Entities[0].ChildRelations) || "z" != ret.Entities[0].ChildRelations[0].Target.Value {
+		t.Error("expected only the file in 2 hops", ret)
+	}
+	// files in depth are not directly related so their relation data stays empty
+	if relation := ret.Entities[0].ChildRelations[0]; 0 != relation.Version || "" != relation.SourceType || "" != relation.TargetType {
+		t.Error("expected no relation data for a file in depth", relation)
+	}
+
+	qry = New().Read("File").Match("Value", "==", "x").FromPath(New().Read("Folder").Match("Value", "==", "root"), 1, 2)
+	ret = Execute(testStorage, qry)
+	if 0 != ret.Amount {
+		t.Error("root folder should not be within 2 hops", ret)
+	}
+
+	qry = New().Read("File").FromPath(New().Read("Folder").Match("Value", "==", "root"), 1, 3)
+	ret = Execute(testStorage, qry)
+	if 3 != ret.Amount {
+		t.Error("expected all files to be below the root folder", ret)
+	}
+}
+
+func TestUpdateUnlinkDeleteToPath(t *testing.T) {
+	initStorage()
+	createHopTestData()
+	defer Cleanup()
+
+	qry := New().Update("Folder").ToPath(New().Find("File").Match("Value", "==", "x"), 2, 3).Set("Context", "hasX")
+	ret := Execute(testStorage, qry)
+	if 2 != ret.Amount {
+		t.Error("expected root and a to be updated", ret)
+	}
+
+	// files in depth have no direct relation so nothing is unlinked
+	qry = New().Unlink("Folder").Match("Value", "==", "root").ToPath(New().Find("File"), 2, 3)
+	ret = Execute(testStorage, qry)
+	if 0 != ret.Amount {
+		t.Error("expected nothing to be unlinked", ret)
+	}
+
+	// only the direct relation root -> y exists to be unlinked
+	qry = New().Unlink("Folder").Match("Value", "==", "root").ToPath(New().Find("File"), 1, 3)
+	ret = Execute(testStorage, qry)
+	if 1 != ret.Amount {
+		t.Error("expected only the direct relation to be unlinked", ret)
+	}
+	ret = Execute(testStorage, New().Read("Folder").Match("Value", "==", "root").To(New().Read("File")))
+	if 0 != ret.Amount {
+		t.Error("direct relation to file y should have been unlinked", ret)
+	}
+	ret = Execute(testStorage, New().Read("Folder").Match("Value", "==", "root").ToPath(New().Read("File"), 1, 3))
+	if 1 != ret.Amount || 2 != len(ret.Entities[0].ChildRelations) {
+		t.Error("files in depth should still be reachable", ret)
+	}
+
+	qry = New().Delete("Folder").ToPath(New().Find("File").Match("Value", "==", "x"), 1, 1)
+	ret = Execute(testStorage, qry)
+	if 1 != ret.Amount || 2 != getEntityCountInTest(t, "Folder") {
+		t.Error("expected only folder b to be deleted", ret)
+	}
+}
+
+func TestReadAsOf(t *testing.T) {
+	initStorage()
+	createHopTestData()
+	defer Cleanup()
+
+	folderType, _ := testStorage.GetTypeIdByString("Folder")
+	testStorage.EnableHistory(folderType, storage.HistoryConfig{})
+	time.Sleep(time.Millisecond)
+	before := time.Now()
+	time.Sleep(time.Millisecond)
+
+	Execute(testStorage, New().Update("Folder").Match("Value", "==", "root").Set("Value", "renamed"))
+	Execute(testStorage, New().Delete("Folder").Match("Value", "==", "b"))
+
+	ret := Execute(testStorage, New().Read("Folder").Match("Value", "==", "root").AsOf(before).ToPath(New().Read("File"), 1, 3))
+	if 1 != ret.Amount || 3 != len(ret.Entities[0].ChildRelations) {
+		t.Error("expected the folder tree as it was before the changes", ret)
+	}
+	ret = Execute(testStorage, New().Read("Folder").Match("Value", "==", "root"))
+	if 0 != ret.Amount {
+		t.Error("root folder should have been renamed", ret)
+	}
+	ret = Execute(testStorage, New().Read("Folder").Match("ID", "==", "1").AsOfVersion(1))
+	if 1 != ret.Amount || "root" != ret.Entities[0].Value {
+		t.Error("expected the first version of the renamed folder", ret)
+	}
+	// conditions and joins use the current state
+	ret = Execute(testStorage, New().Read("Folder").Match("Value", "==", "renamed").AsOfVersion(1).To(New().Read("File")).Select("Value"))
+	if 1 != ret.Amount || "root" != ret.Entities[0].Value || 1 != ret.Entities[0].Version || 1 != len(ret.Entities[0].ChildRelations) {
+		t.Error("expected the first version of the renamed folder with its file", ret)
+	}
+	// entities without the version are left out
+	ret = Execute(testStorage, New().Read("Folder").AsOfVersion(2))
+	if 1 != ret.Amount || "renamed" != ret.Entities[0].Value {
+		t.Error("expected only the renamed folder to have a second version", ret)
+	}
+
+	// sorting uses the values of the version. by the current values
+	// "renamed" comes before "z", in version 1 "a" comes before "root"
+	Execute(testStorage, New().Update("Folder").Match("Value", "==", "a").Set("Value", "z"))
+	ret = Execute(testStorage, New().Read("Folder").Order("Value", ORDER_DIRECTION_ASC, ORDER_MODE_ALPHA).AsOfVersion(1))
+	if 2 != ret.Amount || "a" != ret.Entities[0].Value || "root" != ret.Entities[1].Value {
+		t.Error("expected the folders sorted by the values of version 1", ret)
+	}
+	ret = Execute(testStorage, New().Read("Folder").Order("Value", ORDER_DIRECTION_ASC, ORDER_MODE_ALPHA).Limit(1).AsOfVersion(1))
+	if 1 != ret.Amount || "a" != ret.Entities[0].Value {
+		t.Error("expected the limit to apply to the sorted values of version 1", ret)
+	}
+}
+
+func TestUpdateIfVersion(t *testing.T) {
+	initStorage()
+	createHopTestData()
+	defer Cleanup()
+
+	ret := Execute(testStorage, New().Read("Folder").Match("Value", "==", "a"))
+	version := ret.Entities[0].Version
+
+	// only the entity with the expected version gets updated
+	ret = Execute(testStorage, New().Update("Folder").IfVersion(version).Set("Context", "checked"))
+	if 3 != len(ret.Failed)+ret.Amount {
+		t.Error("expected all folders to be considered", ret)
+	}
+	ret = Execute(testStorage, New().Update("Folder").Match("Value", "==", "a").IfVersion(version).Set("Value", "a2"))
+	if 0 != ret.Amount || 1 != len(ret.Failed) || "Folder" != ret.Failed[0].Type || version+1 != ret.Failed[0].Version {
+		t.Error("expected a version mismatch", ret)
+	}
+	ret = Execute(testStorage, New().Update("Folder").Match("Value", "==", "a").IfVersion(version+1).Set("Value", "a2"))
+	if 1 != ret.Amount || 0 != len(ret.Failed) {
+		t.Error("expected the update to succeed", ret)
+	}
+
+	// versions of single entities override the general one
+	ret = Execute(testStorage, New().Read("Folder").Match("Value", "==", "root"))
+	root := ret.Entities[0]
+	ret = Execute(testStorage, New().Update("Folder").IfVersion(-1).IfEntityVersion("Folder", root.ID, root.Version).Set("Context", "root"))
+	if 1 != ret.Amount || 2 != len(ret.Failed) {
+		t.Error("expected only the root folder to be updated", ret)
+	}
+	ret = Execute(testStorage, New().Read("Folder").Match("Context", "==", "root"))
+	if 1 != ret.Amount || root.ID != ret.Entities[0].ID {
+		t.Error("expected the root folder to be updated", ret)
+	}
+}
+
+func TestUpdateOperations(t *testing.T) {
+	initStorage()
+	defer Cleanup()
+	testStorage.MapTransportData(transport.TransportEntity{
+		ID:         storage.MAP_FORCE_CREATE,
+		Type:       "Counter",
+		Value:      "visits",
+		Properties: map[string]string{"count": "0", "price": "1.5", "tags": "a"},
+	})
+
+	// concurrent increments must not lose updates
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Execute(testStorage, New().Update("Counter").Increment("Properties.count", 2))
+		}()
+	}
+	wg.Wait()
+
+	ret := Execute(testStorage, New().Update("Counter").Increment("Properties.price", 1).Append("Properties.tags", ",x").SetIfEmpty("Properties.owner", "me").SetIfEmpty("Value", "ignored"))
+	if 1 != ret.Amount {
+		t.Error("expected the counter to be updated", ret)
+	}
+	ret = Execute(testStorage, New().Read("Counter"))
+	props := ret.Entities[0].Properties
+	if "100" != props["count"] || "2.5" != props["price"] || "a,x" != props["tags"] || "me" != props["owner"] || "visits" != ret.Entities[0].Value {
+		t.Error("unexpected result of update operations", ret.Entities[0])
+	}
+
+	// failing operations leave the whole entity unchanged
+	ret = Execute(testStorage, New().Update("Counter").Set("Context", "changed").CompareAndSet("Properties.owner", "you", "them"))
+	if 0 != ret.Amount || 1 != len(ret.Failed) {
+		t.Error("expected compare and set to fail", ret)
+	}
+	ret = Execute(testStorage, New().Update("Counter").Increment("Value", 1))
+	if 0 != ret.Amount || 1 != len(ret.Failed) {
+		t.Error("expected increment of non numeric value to fail", ret)
+	}
+	ret = Execute(testStorage, New().Update("Counter").CompareAndSet("Properties.owner", "me", "them"))
+	if 1 != ret.Amount {
+		t.Error("expected compare and set to succeed", ret)
+	}
+	ret = Execute(testStorage, New().Read("Counter"))
+	if "them" != ret.Entities[0].Properties["owner"] || "" != ret.Entities[0].Context {
+		t.Error("unexpected entity after compare and set", ret.Entities[0])
+	}
+}
+
+func TestUpdateUnset(t *testing.T) {
+	initStorage()
+	defer Cleanup()
+	testStorage.MapTransportData(transport.TransportEntity{
+		ID:         storage.MAP_FORCE_CREATE,
+		Type:       "Server",
+		Value:      "alpha",
+		Context:    "dc1",
+		Properties: map[string]string{"stale": "1", "name": "a", "owner": "me"},
+	})
+
+	ret := Execute(testStorage, New().Update("Server").Unset("Properties.stale").Unset("Context"))
+	if 1 != ret.Amount {
+		t.Error("expected the server to be updated", ret)
+	}
+	ret = Execute(testStorage, New().Read("Server"))
+	if _, ok := ret.Entities[0].Properties["stale"]; ok || "a" != ret.Entities[0].Properties["name"] || "" != ret.Entities[0].Context {
+		t.Error("expected property and context to be unset", ret.Entities[0])
+	}
+
+	ret = Execute(testStorage, New().Update("Server").ClearProperties().Set("Properties.fresh", "1"))
+	ret = Execute(testStorage, New().Read("Server"))
+	if 0 != len(ret.Entities[0].Properties) {
+		t.Error("expected all properties to be removed after setting", ret.Entities[0])
+	}
+	ret = Execute(testStorage, New().Update("Server").Set("Properties.fresh", "1"))
+	ret = Execute(testStorage, New().Read("Server"))
+	if "1" != ret.Entities[0].Properties["fresh"] {
+		t.Error("expected property to be set on cleared map", ret.Entities[0])
+	}
+}
+
+func TestReturning(t *testing.T) {
+	initStorage()
+	createHopTestData()
+	defer Cleanup()
+
+	ret := Execute(testStorage, New().Update("Folder").Match("Value", "==", "b").Set("Context", "updated"))
+	if 1 != ret.Amount || nil != ret.Entities {
+		t.Error("expected no entities without returning", ret)
+	}
+	ret = Execute(testStorage, New().Update("Folder").Match("Value", "==", "b").Set("Properties.size", "1").Returning())
+	if 1 != len(ret.Entities) || "updated" != ret.Entities[0].Context || "1" != ret.Entities[0].Properties["size"] || 3 != ret.Entities[0].Version {
+		t.Error("expected the updated entity to be returned", ret)
+	}
+
+	// cascading deletes return everything that got deleted
+	ret = Execute(testStorage, New().Delete("Folder").Match("Value", "==", "a").CascadeOut(0).Returning())
+	if 3 != ret.Amount || 3 != len(ret.Entities) || 3 != len(ret.Relations) {
+		t.Error("expected a, b and x with their relations to be returned", ret)
+	}
+	if "Folder" != ret.Entities[0].Type || "a" != ret.Entities[0].Value || "x" != ret.Entities[2].Value {
+		t.Error("unexpected deleted entities", ret.Entities)
+	}
+	if "root" != Execute(testStorage, New().Read("Folder").Match("ID", "==", strconv.Itoa(ret.Relations[0].SourceID))).Entities[0].Value {
+		t.Error("expected the relation from the root folder to be returned", ret.Relations)
+	}
+
+	ret = Execute(testStorage, New().Delete("File").Match("Value", "==", "y").Returning())
+	if 1 != len(ret.Entities) || 1 != len(ret.Relations) || "File" != ret.Relations[0].TargetType {
+		t.Error("expected the deleted file and its relation", ret)
+	}
+	if 3 != Execute(testStorage, New().Read("Folder", "File", "Link")).Amount {
+		t.Error("expected root, link and z to remain")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	initStorage()
+	createHopTestData()
+	defer Cleanup()
+
+	ret := Execute(testStorage, New().Delete("Folder").Match("Value", "==", "a").CascadeOut(0).DryRun())
+	if 3 != ret.Amount || 3 != len(ret.Entities) || 3 != len(ret.Relations) {
+		t.Error("expected a, b and x with their relations to be reported", ret)
+	}
+
+	ret = Execute(testStorage, New().Update("Folder").Match("Value", "==", "a").Set("Value", "a2").Increment("Properties.size", 1).DryRun())
+	if 1 != ret.Amount || "a2" != ret.Entities[0].Value || "1" != ret.Entities[0].Properties["size"] || 2 != ret.Entities[0].Version {
+		t.Error("expected a preview of the updated entity", ret)
+	}
+	ret = Execute(testStorage, New().Update("Folder").Match("Value", "==", "a").IfVersion(5).Set("Value", "a2").DryRun())
+	if 0 != ret.Amount || 1 != len(ret.Failed) {
+		t.Error("expected the version mismatch to be reported", ret)
+	}
+
+	ret = Execute(testStorage, New().Link("Folder").Match("Value", "==", "root").To(New().Find("File")).DryRun())
+	if 2 != ret.Amount || 2 != len(ret.Relations) || "File" != ret.Relations[0].TargetType {
+		t.Error("expected the links to x and z to be reported", ret)
+	}
+
+	ret = Execute(testStorage, New().Unlink("Folder").Match("Value", "==", "root").To(New().Find("File")).DryRun())
+	if 1 != ret.Amount || 1 != len(ret.Relations) {
+		t.Error("expected the relation to y to be reported", ret)
+	}
+
+	// nothing has been changed
+	ret = Execute(testStorage, New().Read("Folder").Match("Value", "==", "a").To(New().Read("Folder").To(New().Read("File"))))
+	if 1 != ret.Amount || 1 != ret.Entities[0].Version {
+		t.Error("expected the storage to be unchanged", ret)
+	}
+	ret = Execute(testStorage, New().Read("Folder").Match("Value", "==", "root").To(New().Read("File")))
+	if 1 != len(ret.Entities[0].ChildRelations) {
+		t.Error("expected no links to be created or removed", ret)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	initStorage()
+	defer Cleanup()
+	for i := 0; i < 25; i++ {
+		item := transport.TransportEntity{
+			ID:         storage.MAP_FORCE_CREATE,
+			Type:       "Item",
+			Value:      "item" + strconv.Itoa(i),
+			Properties: map[string]string{"rank": strconv.Itoa(i % 5)},
+		}
+		if 0 == i%2 {
+			item.ChildRelations = []transport.TransportRelation{{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Tag", Value: "even"}}}
+		}
+		testStorage.MapTransportData(item)
+	}
+
+	// walking the pages by cursor returns every entity once in order
+	var seen []int
+	cursor := ""
+	pages := 0
+	for {
+		ret := Execute(testStorage, New().Read("Item").Order("Properties.rank", ORDER_DIRECTION_DESC, ORDER_MODE_NUM).Limit(10).After(cursor))
+		for _, entity := range ret.Entities {
+			seen = append(seen, entity.ID)
+		}
+		pages++
+		if 1 == pages {
+			// entities inserted before the cursor dont shift later pages
+			testStorage.MapTransportData(transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Item", Value: "late", Properties: map[string]string{"rank": "9"}})
+		}
+		if "" == ret.Cursor {
+			break
+		}
+		cursor = ret.Cursor
+	}
+	if 3 != pages || 25 != len(seen) {
+		t.Error("expected 25 entities on 3 pages", pages, seen)
+	}
+	unique := make(map[int]bool)
+	for _, id := range seen {
+		unique[id] = true
+	}
+	if 25 != len(unique) || 5 != seen[0] || 10 != seen[1] || 21 != seen[24] {
+		t.Error("expected entities ordered by rank and id", seen)
+	}
+
+	// offsets and joined reads page the same way
+	ret := Execute(testStorage, New().Read("Item").Order("Properties.rank", ORDER_DIRECTION_ASC, ORDER_MODE_NUM).Offset(3).Limit(4))
+	joined := Execute(testStorage, New().Read("Item").Order("Properties.rank", ORDER_DIRECTION_ASC, ORDER_MODE_NUM).CanTo(New().Read("Tag")).Offset(3).Limit(4))
+	if 4 != ret.Amount || 4 != joined.Amount || "" == ret.Cursor || ret.Cursor != joined.Cursor {
+		t.Error("expected equal pages with and without join", ret, joined)
+	}
+	for i := range ret.Entities {
+		if ret.Entities[i].ID != joined.Entities[i].ID {
+			t.Error("expected equal order with and without join", ret.Entities, joined.Entities)
+		}
+	}
+	ret = Execute(testStorage, New().Read("Item").Offset(24))
+	if 2 != ret.Amount || "" != ret.Cursor {
+		t.Error("expected the last two entities without cursor", ret)
+	}
+}
+
+func TestOrderByAndSubqueryLimit(t *testing.T) {
+	initStorage()
+	defer Cleanup()
+	customers := [][2]string{{"anna", "berlin"}, {"bert", "athens"}, {"carl", "berlin"}, {"dora", "athens"}}
+	for i, customer := range customers {
+		var orders []transport.TransportRelation
+		for j := 1; j <= 3+i; j++ {
+			orders = append(orders, transport.TransportRelation{Target: transport.TransportEntity{
+				ID:         storage.MAP_FORCE_CREATE,
+				Type:       "Order",
+				Value:      customer[0] + strconv.Itoa(j),
+				Properties: map[string]string{"date": "2024-01-0" + strconv.Itoa(j)},
+			}})
+		}
+		testStorage.MapTransportData(transport.TransportEntity{
+			ID:             storage.MAP_FORCE_CREATE,
+			Type:           "Customer",
+			Value:          customer[0],
+			Properties:     map[string]string{"city": customer[1]},
+			ChildRelations: orders,
+		})
+	}
+
+	qry := New().Read("Customer").
+		OrderBy("Properties.city", ORDER_DIRECTION_ASC, ORDER_MODE_ALPHA).
+		OrderBy("Value", ORDER_DIRECTION_DESC, ORDER_MODE_ALPHA).
+		To(New().Read("Order").Order("Properties.date", ORDER_DIRECTION_DESC, ORDER_MODE_ALPHA).Limit(2))
+	ret := Execute(testStorage, qry)
+	var names []string
+	for _, entity := range ret.Entities {
+		names = append(names, entity.Value)
+	}
+	if 4 != len(names) || "dora" != names[0] || "bert" != names[1] || "carl" != names[2] || "anna" != names[3] {
+		t.Error("expected customers ordered by city and name descending", names)
+	}
+	for _, entity := range ret.Entities {
+		if 2 != len(entity.ChildRelations) {
+			t.Error("expected the latest two orders per customer", entity)
+			continue
+		}
+		latest := entity.ChildRelations[0].Target.Properties["date"]
+		if latest <= entity.ChildRelations[1].Target.Properties["date"] {
+			t.Error("expected orders ordered by date descending", entity.ChildRelations)
+		}
+	}
+	if "dora6" != ret.Entities[0].ChildRelations[0].Target.Value {
+		t.Error("expected the latest order of dora first", ret.Entities[0].ChildRelations)
+	}
+
+	// order replaces all previous orders
+	qry = New().Read("Customer").OrderBy("Properties.city", ORDER_DIRECTION_ASC, ORDER_MODE_ALPHA).Order("Value", ORDER_DIRECTION_ASC, ORDER_MODE_ALPHA)
+	ret = Execute(testStorage, qry)
+	if "anna" != ret.Entities[0].Value || "dora" != ret.Entities[3].Value {
+		t.Error("expected customers ordered by name only", ret.Entities)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	initStorage()
+	defer Cleanup()
+	testStorage.MapTransportData(transport.TransportEntity{
+		ID:         storage.MAP_FORCE_CREATE,
+		Type:       "Customer",
+		Value:      "anna",
+		Context:    "crm",
+		Properties: map[string]string{"name": "Anna", "notes": "long text", "rank": "2"},
+		ChildRelations: []transport.TransportRelation{
+			{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Order", Value: "o1", Context: "shop", Properties: map[string]string{"total": "10", "items": "many"}}},
+		},
+	})
+
+	ret := Execute(testStorage, New().Read("Customer").Select("Value", "Properties.name").To(New().Read("Order").Select("Properties.total")))
+	customer := ret.Entities[0]
+	if "anna" != customer.Value || "" != customer.Context || 1 != len(customer.Properties) || "Anna" != customer.Properties["name"] || 1 != customer.Version {
+		t.Error("expected only value and name of the customer", customer)
+	}
+	order := customer.ChildRelations[0].Target
+	if "" != order.Value || 1 != len(order.Properties) || "10" != order.Properties["total"] || "Order" != order.Type || 0 == order.ID {
+		t.Error("expected only identity and total of the order", order)
+	}
+
+	// ordered fields are returned too and identities only can be selected
+	ret = Execute(testStorage, New().Read("Customer").Select("Context").Order("Properties.rank", ORDER_DIRECTION_ASC, ORDER_MODE_NUM))
+	if "crm" != ret.Entities[0].Context || "2" != ret.Entities[0].Properties["rank"] || 1 != len(ret.Entities[0].Properties) {
+		t.Error("expected context and ordered field", ret.Entities[0])
+	}
+	ret = Execute(testStorage, New().Read("Customer").Select("ID").Limit(1))
+	if "" != ret.Entities[0].Value || 0 != len(ret.Entities[0].Properties) || 1 != ret.Entities[0].ID {
+		t.Error("expected the identity only", ret.Entities[0])
+	}
+	ret = Execute(testStorage, New().Read("Customer").Select("Properties"))
+	if 3 != len(ret.Entities[0].Properties) || "" != ret.Entities[0].Value {
+		t.Error("expected all properties only", ret.Entities[0])
+	}
+}
+
+func TestFlatResults(t *testing.T) {
+	initStorage()
+	defer Cleanup()
+	testStorage.MapTransportData(transport.TransportEntity{
+		ID:         storage.MAP_FORCE_CREATE,
+		Type:       "Customer",
+		Value:      "anna",
+		Properties: map[string]string{"city": "berlin"},
+		ChildRelations: []transport.TransportRelation{
+			{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Order", Value: "o1", Properties: map[string]string{"total": "10"}}},
+			{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Order", Value: "o2", Properties: map[string]string{"total": "20"}}},
+			{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Tag", Value: "vip"}},
+		},
+	})
+	testStorage.MapTransportData(transport.TransportEntity{
+		ID:    storage.MAP_FORCE_CREATE,
+		Type:  "Customer",
+		Value: "bert",
+	})
+
+	qry := New().Read("Customer").As("c").Order("Value", ORDER_DIRECTION_ASC, ORDER_MODE_ALPHA).
+		CanTo(New().Read("Order").As("o").Select("Properties.total")).
+		CanTo(New().Read("Tag").Select("Value")).
+		Flat()
+	ret := Execute(testStorage, qry)
+	expectedColumns := "c.Type,c.ID,c.Value,c.Context,c.Version,c.Properties.city,o.Type,o.ID,o.Version,o.Properties.total,Tag.Type,Tag.ID,Tag.Value,Tag.Version"
+	if expectedColumns != strings.Join(ret.Columns, ",") {
+		t.Error("unexpected columns", ret.Columns)
+	}
+	if 3 != len(ret.Rows) || 2 != ret.Amount {
+		t.Error("expected a row per order of anna and one for bert", ret.Rows)
+	}
+	var buffer bytes.Buffer
+	if err := ret.WriteCSV(&buffer); nil != err {
+		t.Error(err)
+	}
+	expectedCSV := expectedColumns + "\n" +
+		"Customer,1,anna,,1,berlin,Order,1,1,10,Tag,1,vip,1\n" +
+		"Customer,1,anna,,1,berlin,Order,2,1,20,Tag,1,vip,1\n" +
+		"Customer,2,bert,,1,,,,,,,,,\n"
+	if expectedCSV != buffer.String() {
+		t.Error("unexpected csv", buffer.String())
+	}
+
+	// required joins drop entities without match, reduced ones only filter
+	qry = New().Read("Customer").Select("Value").To(New().Reduce("Order").Match("Properties.total", ">", "15")).Flat()
+	ret = Execute(testStorage, qry)
+	if "Customer.Type,Customer.ID,Customer.Value,Customer.Version" != strings.Join(ret.Columns, ",") || 1 != len(ret.Rows) || "anna" != ret.Rows[0][2] {
+		t.Error("expected only anna without order columns", ret.Columns, ret.Rows)
+	}
+
+	// rows follow the nested result, joins of the same type are kept apart
+	qry = New().Read("Customer").Select("Value").Match("Value", "==", "anna").
+		CanTo(New().Read("Order").As("big").Select("Value").Match("Properties.total", ">", "15")).
+		CanTo(New().Read("Order").As("last").Select("Value").Order("Value", ORDER_DIRECTION_DESC, ORDER_MODE_ALPHA).Limit(1)).
+		Flat()
+	ret = Execute(testStorage, qry)
+	if "Customer.Type,Customer.ID,Customer.Value,Customer.Version,big.Type,big.ID,big.Value,big.Version,last.Type,last.ID,last.Value,last.Version" != strings.Join(ret.Columns, ",") {
+		t.Error("unexpected columns", ret.Columns)
+	}
+	if 1 != len(ret.Rows) || 2 != len(ret.Entities[0].ChildRelations) || "o2" != ret.Rows[0][6] || "o2" != ret.Rows[0][10] {
+		t.Error("expected a single row with o2 in both joins", ret.Rows, ret.Entities)
+	}
+	// relations added by traversing are not part of the rows
+	qry = New().Read("Customer").Select("Value").Match("Value", "==", "anna").TraverseOut(1).
+		To(New().Read("Tag").Select("Value")).
+		Flat()
+	ret = Execute(testStorage, qry)
+	if 1 != len(ret.Rows) || "vip" != ret.Rows[0][6] || 2 > len(ret.Entities[0].ChildRelations) {
+		t.Error("expected a single row with the tag", ret.Rows)
+	}
+}
+
+func TestAliasConditions(t *testing.T) {
+	initStorage()
+	defer Cleanup()
+	testStorage.MapTransportData(transport.TransportEntity{
+		ID:         storage.MAP_FORCE_CREATE,
+		Type:       "Customer",
+		Value:      "anna",
+		Properties: map[string]string{"region": "north"},
+		ChildRelations: []transport.TransportRelation{
+			{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Order", Value: "o1", Properties: map[string]string{"region": "north"}}},
+			{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Order", Value: "o2", Properties: map[string]string{"region": "south"}}},
+		},
+	})
+	testStorage.MapTransportData(transport.TransportEntity{
+		ID:         storage.MAP_FORCE_CREATE,
+		Type:       "Customer",
+		Value:      "bert",
+		Properties: map[string]string{"region": "south"},
+		ChildRelations: []transport.TransportRelation{
+			{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Order", Value: "o3", Properties: map[string]string{"region": "north"}}},
+		},
+	})
+
+	qry := New().Read("Customer").As("customer").Order("Value", ORDER_DIRECTION_ASC, ORDER_MODE_ALPHA).
+		To(New().Read("Order").Match("Properties.region", "==", "$customer.Properties.region"))
+	ret := Execute(testStorage, qry)
+	if 1 != ret.Amount || "anna" != ret.Entities[0].Value {
+		t.Error("expected only anna to have an order shipped to her region", ret.Entities)
+	} else if 1 != len(ret.Entities[0].ChildRelations) || "o1" != ret.Entities[0].ChildRelations[0].Target.Value {
+		t.Error("expected only o1 to be joined", ret.Entities[0].ChildRelations)
+	}
+
+	// aliases of all parent queries can be referenced
+	testStorage.MapTransportData(transport.TransportEntity{
+		ID:         storage.MAP_FORCE_CREATE,
+		Type:       "Customer",
+		Value:      "carl",
+		Properties: map[string]string{"region": "north"},
+		ChildRelations: []transport.TransportRelation{
+			{Target: transport.TransportEntity{ID: 1, Type: "Order"}},
+		},
+	})
+	qry = New().Read("Customer").As("customer").Match("Value", "==", "anna").
+		To(New().Read("Order").As("order").
+			From(New().Read("Customer").Match("Value", "!=", "$customer.Value").Match("Properties.region", "==", "$order.Properties.region"))).
+		CanTo(New().Read("Order").Match("Value", "!=", "$unknown.Value"))
+	ret = Execute(testStorage, qry)
+	if 1 != ret.Amount || 1 != len(ret.Entities[0].ChildRelations) {
+		t.Error("expected anna with a single order", ret.Entities)
+	} else if parents := ret.Entities[0].ChildRelations[0].Target.ParentRelations; 1 != len(parents) || "carl" != parents[0].Target.Value {
+		t.Error("expected carl as the other customer of the order", parents)
+	}
+	if "$order.Properties.region" != qry.Map[0].Map[0].Conditions[0][1][2] {
+		t.Error("expected the query to stay untouched")
+	}
+
+	// references to unknown aliases or missing fields never match
+	for _, beta := range []string{"$custmer.Properties.region", "$customer.Properties.missing"} {
+		qry = New().Read("Customer").As("customer").To(New().Read("Order").Match("Properties.region", "!=", beta))
+		if ret = Execute(testStorage, qry); 0 != ret.Amount {
+			t.Error("expected no match for the reference", beta, ret.Entities)
+		}
+	}
+	// values which are no references are compared as they are
+	qry = New().Read("Customer").As("customer").To(New().Read("Order").Match("Properties.region", "!=", "$5.00"))
+	if ret = Execute(testStorage, qry); 3 != ret.Amount {
+		t.Error("expected all customers with orders", ret.Entities)
+	}
+}
+
+func TestNegatedJoins(t *testing.T) {
+	initStorage()
+	defer Cleanup()
+	testStorage.MapTransportData(transport.TransportEntity{
+		ID:    storage.MAP_FORCE_CREATE,
+		Type:  "Customer",
+		Value: "anna",
+		ChildRelations: []transport.TransportRelation{
+			{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Order", Value: "o1", Properties: map[string]string{"state": "open"}}},
+		},
+	})
+	testStorage.MapTransportData(transport.TransportEntity{
+		ID:    storage.MAP_FORCE_CREATE,
+		Type:  "Customer",
+		Value: "bert",
+		ChildRelations: []transport.TransportRelation{
+			{Target: transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Order", Value: "o2", Properties: map[string]string{"state": "done"}}},
+		},
+	})
+	testStorage.MapTransportData(transport.TransportEntity{
+		ID:    storage.MAP_FORCE_CREATE,
+		Type:  "Customer",
+		Value: "carl",
+	})
+	testStorage.MapTransportData(transport.TransportEntity{
+		ID:    storage.MAP_FORCE_CREATE,
+		Type:  "Order",
+		Value: "o3",
+	})
+
+	qry := New().Read("Customer").Order("Value", ORDER_DIRECTION_ASC, ORDER_MODE_ALPHA).NotTo(New().Read("Order").Match("Properties.state", "==", "open"))
+	ret := Execute(testStorage, qry)
+	if 2 != ret.Amount || "bert" != ret.Entities[0].Value || "carl" != ret.Entities[1].Value {
+		t.Error("expected the customers without open orders", ret.Entities)
+	}
+	if 0 != len(ret.Entities[0].ChildRelations) {
+		t.Error("expected negated joins not to return entities", ret.Entities[0].ChildRelations)
+	}
+
+	// orphaned orders
+	ret = Execute(testStorage, New().Read("Order").NotFrom(New().Reduce("Customer")))
+	if 1 != ret.Amount || "o3" != ret.Entities[0].Value {
+		t.Error("expected only the orphaned order", ret.Entities)
+	}
+
+	// combined with required joins and nested joins
+	qry = New().Read("Customer").
+		To(New().Read("Order")).
+		NotTo(New().Reduce("Order").From(New().Reduce("Customer").Match("Value", "==", "anna")))
+	ret = Execute(testStorage, qry)
+	if 1 != ret.Amount || "bert" != ret.Entities[0].Value || 1 != len(ret.Entities[0].ChildRelations) {
+		t.Error("expected bert with the order", ret.Entities)
+	}
+
+	// negated joins have no columns in flat results
+	ret = Execute(testStorage, New().Read("Customer").Select("Value").NotTo(New().Read("Order")).Flat())
+	if "Customer.Type,Customer.ID,Customer.Value,Customer.Version" != strings.Join(ret.Columns, ",") || 1 != len(ret.Rows) || "carl" != ret.Rows[0][2] {
+		t.Error("expected only carl without order columns", ret.Columns, ret.Rows)
+	}
+
+	// links need matches so negated joins are rejected
+	ret = Execute(testStorage, New().Link("Customer").Match("Value", "==", "carl").NotTo(New().Find("Order")))
+	if 0 != ret.Amount || 0 != Execute(testStorage, New().Read("Customer").Match("Value", "==", "carl").To(New().Reduce("Order"))).Amount {
+		t.Error("expected nothing to be linked", ret)
+	}
+
+	ret = Execute(testStorage, New().Delete("Customer").NotTo(New().Reduce("Order")))
+	if 1 != ret.Amount || 2 != len(testStorage.EntityStorage[testStorage.EntityRTypes["Customer"]]) {
+		t.Error("expected only carl to be deleted", ret.Amount)
+	}
+}
+
+func TestConditionTrees(t *testing.T) {
+	initStorage()
+	defer Cleanup()
+	for _, props := range []map[string]string{
+		{"a": "1", "c": "1"},
+		{"b": "1", "d": "1"},
+		{"a": "1", "b": "1"},
+		{"c": "1", "d": "1"},
+		{"a": "1", "d": "1", "flag": "x"},
+	} {
+		testStorage.MapTransportData(transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Item", Value: "item", Properties: props})
+	}
+	getIDs := func(ret transport.Transport) string {
+		var ids []string
+		for _, entity := range ret.Entities {
+			ids = append(ids, strconv.Itoa(entity.ID))
+		}
+		return strings.Join(ids, ",")
+	}
+
+	// (a or b) and (c or d)
+	qry := New().Read("Item").Order("ID", ORDER_DIRECTION_ASC, ORDER_MODE_NUM).
+		Where(And(
+			Or(Cond("Properties.a", "==", "1"), Cond("Properties.b", "==", "1")),
+			Or(Cond("Properties.c", "==", "1"), Cond("Properties.d", "==", "1")),
+		))
+	if ids := getIDs(Execute(testStorage, qry)); "1,2,5" != ids {
+		t.Error("unexpected entities for nested groups", ids)
+	}
+
+	// types are case insensitive and conditions without type but with a match are matches
+	qry = New().Read("Item").Order("ID", ORDER_DIRECTION_ASC, ORDER_MODE_NUM).
+		Where(Condition{Type: "or", Conditions: []Condition{{Match: [3]string{"Properties.b", "==", "1"}}, {Type: "match", Match: [3]string{"Properties.c", "==", "1"}}}})
+	if ids := getIDs(Execute(testStorage, qry)); "1,2,3,4" != ids {
+		t.Error("unexpected entities for lowercase types", ids)
+	}
+	// unknown types are reported
+	qry = New().Read("Item").To(New().Read("Item").Where(Condition{Type: "Xor"}))
+	if ret := Execute(testStorage, qry); 0 != ret.Amount || 1 != len(ret.Failed) || "Invalid condition type Xor." != ret.Failed[0].Error {
+		t.Error("expected the invalid condition type to be reported", ret)
+	}
+	if _, err := ExecutePaths(testStorage, New().Read("Item").Where(Condition{}), New().Read("Item"), storage.PathOptions{}); nil == err {
+		t.Error("expected an error for an empty condition")
+	}
+
+	// negated conditions also match entities missing the property
+	qry = New().Read("Item").Order("ID", ORDER_DIRECTION_ASC, ORDER_MODE_NUM).Where(Not(Cond("Properties.flag", "==", "x")))
+	if ids := getIDs(Execute(testStorage, qry)); "1,2,3,4" != ids {
+		t.Error("unexpected entities for negated condition", ids)
+	}
+
+	// Match and OrMatch have to match in addition to the tree
+	qry = New().Read("Item").Order("ID", ORDER_DIRECTION_ASC, ORDER_MODE_NUM).
+		Match("Properties.a", "==", "1").OrMatch("Properties.c", "==", "1").
+		Where(Not(Or(Cond("Properties.d", "==", "1"), Cond("Properties.b", "==", "1"))))
+	if ids := getIDs(Execute(testStorage, qry)); "1" != ids {
+		t.Error("unexpected entities for combined conditions", ids)
+	}
+
+	// trees on subqueries and in combination with alias references
+	testStorage.MapTransportData(transport.TransportEntity{
+		ID:         storage.MAP_FORCE_CREATE,
+		Type:       "Box",
+		Value:      "box",
+		Properties: map[string]string{"wanted": "1"},
+		ChildRelations: []transport.TransportRelation{
+			{Target: transport.TransportEntity{ID: 1, Type: "Item"}},
+			{Target: transport.TransportEntity{ID: 4, Type: "Item"}},
+		},
+	})
+	qry = New().Read("Box").As("box").To(New().Read("Item").Where(Or(Cond("Properties.b", "==", "1"), Cond("Properties.a", "==", "$box.Properties.wanted"))))
+	ret := Execute(testStorage, qry)
+	if 1 != ret.Amount || 1 != len(ret.Entities[0].ChildRelations) || 1 != ret.Entities[0].ChildRelations[0].Target.ID {
+		t.Error("expected only item 1 to be joined", ret.Entities)
+	}
+}
+
+func TestExistsOperators(t *testing.T) {
+	initStorage()
+	defer Cleanup()
+	testStorage.MapTransportData(transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Product", Value: "Lamp", Properties: map[string]string{"price": "19.99"}})
+	testStorage.MapTransportData(transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Product", Value: "chair"})
+
+	ret := Execute(testStorage, New().Read("Product").Match("Properties.price", "exists", ""))
+	if 1 != ret.Amount || "Lamp" != ret.Entities[0].Value {
+		t.Error("expected only the product with a price", ret.Entities)
+	}
+	ret = Execute(testStorage, New().Read("Product").Match("Properties.price", "notexists", "").Match("Value", "iregex", "^CH"))
+	if 1 != ret.Amount || "chair" != ret.Entities[0].Value {
+		t.Error("expected only the product without a price", ret.Entities)
+	}
+	ret = Execute(testStorage, New().Read("Product").Where(Or(Cond("Properties.price", "notexists", ""), Cond("Properties.price", "between", "10,20.5"))))
+	if 2 != ret.Amount {
+		t.Error("expected both products", ret.Entities)
+	}
+}
+
+func TestCustomOperatorsAndTransforms(t *testing.T) {
+	initStorage()
+	defer Cleanup()
+	defer storage.UnregisterOperator("semver>=")
+	err := storage.RegisterOperator("semver>=", func(alpha string, beta string) bool {
+		alphaParts := strings.Split(alpha, ".")
+		betaParts := strings.Split(beta, ".")
+		for i := 0; i < len(alphaParts) && i < len(betaParts); i++ {
+			alphaPart, _ := strconv.Atoi(alphaParts[i])
+			betaPart, _ := strconv.Atoi(betaParts[i])
+			if alphaPart != betaPart {
+				return alphaPart > betaPart
+			}
+		}
+		return len(alphaParts) >= len(betaParts)
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	testStorage.MapTransportData(transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Package", Value: "Alpha", Properties: map[string]string{"version": "1.10.0", "meta": `{"owner":{"name":"Anna"}}`}})
+	testStorage.MapTransportData(transport.TransportEntity{ID: storage.MAP_FORCE_CREATE, Type: "Package", Value: "beta", Properties: map[string]string{"version": "1.9.3", "meta": `{"owner":{"name":"bert"}}`}})
+
+	ret := Execute(testStorage, New().Read("Package").Match("Properties.version", "semver>=", "1.10"))
+	if 1 != ret.Amount || "Alpha" != ret.Entities[0].Value {
+		t.Error("expected only the package with version 1.10.0", ret.Entities)
+	}
+	ret = Execute(testStorage, New().Read("Package").Match("Value|lower", "==", "alpha").OrMatch("Properties.meta|json:owner.name|len", "<=", "4"))
+	if 2 != ret.Amount {
+		t.Error("expected both packages", ret.Entities)
+	}
+
+	// operators and transforms can be used in queries built as json
+	var qry Query
+	err = json.Unmarshal([]byte(`{"Method": 1, "Pool": ["Package"], "Direction": -1, "Filter": [
+		{"Type": "Not", "Conditions": [{"Type": "Match", "Match": ["Properties.meta|json:owner.name|lower", "==", "anna"]}]},
+		{"Type": "Match", "Match": ["Properties.version", "semver>=", "1.2"]}
+	]}`), &qry)
+	if nil != err {
+		t.Error(err)
+	}
+	ret = Execute(testStorage, &qry)
+	if 1 != ret.Amount || "beta" != ret.Entities[0].Value {
+		t.Error("expected only beta", ret.Entities)
+	}
+}
+
 func Cleanup() {
 	testStorage.EntityStorage = make(map[int]map[int]types.StorageEntity)
 	testStorage.EntityIDMax = make(map[int]int)
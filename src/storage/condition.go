@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"strconv"
+
+	"github.com/voodooEntity/gits/src/types"
+)
+
+// types of condition tree nodes
+const (
+	// matches if the condition group with the index Group matches
+	CONDITION_GROUP = "Group"
+	// matches if all children match
+	CONDITION_AND = "And"
+	// matches if any child matches
+	CONDITION_OR = "Or"
+	// matches if none of the children matches
+	CONDITION_NOT = "Not"
+)
+
+// node of a condition tree. the leafs reference condition groups
+// by their index so the filters prepared for the groups are used
+// to evaluate them
+type ConditionNode struct {
+	Type     string
+	Group    int
+	Children []ConditionNode
+}
+
+// checks if an entity matches the condition tree
+func (s *Storage) matchConditionTree(
+	node *ConditionNode,
+	entity types.StorageEntity,
+	entityID int,
+	conditions [][][3]string,
+	idFilter [][]int,
+	valueFilter [][]int,
+	contextFilter [][]int,
+	propertyList []map[string][]int,
+) bool {
+	switch node.Type {
+	case CONDITION_GROUP:
+		if 0 > node.Group || len(conditions) <= node.Group {
+			return false
+		}
+		return s.matchConditionGroup(node.Group, entity, entityID, conditions, idFilter, valueFilter, contextFilter, propertyList)
+	case CONDITION_AND:
+		for key := range node.Children {
+			if !s.matchConditionTree(&node.Children[key], entity, entityID, conditions, idFilter, valueFilter, contextFilter, propertyList) {
+				return false
+			}
+		}
+		return true
+	case CONDITION_OR:
+		for key := range node.Children {
+			if s.matchConditionTree(&node.Children[key], entity, entityID, conditions, idFilter, valueFilter, contextFilter, propertyList) {
+				return true
+			}
+		}
+		return false
+	case CONDITION_NOT:
+		for key := range node.Children {
+			if s.matchConditionTree(&node.Children[key], entity, entityID, conditions, idFilter, valueFilter, contextFilter, propertyList) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// checks if an entity matches all conditions of a condition group
+func (s *Storage) matchConditionGroup(
+	conditionGroupKey int,
+	entity types.StorageEntity,
+	entityID int,
+	conditions [][][3]string,
+	idFilter [][]int,
+	valueFilter [][]int,
+	contextFilter [][]int,
+	propertyList []map[string][]int,
+) bool {
+	conditionGroup := conditions[conditionGroupKey]
+	// first we check if there is an ID filter
+	// ### could have a special case for == on
+	// id since this can be resolved very fast
+	if 0 < len(idFilter[conditionGroupKey]) && !s.matchGroup(idFilter[conditionGroupKey], conditionGroup, strconv.Itoa(entityID)) {
+		return false
+	}
+	// now we value
+	if 0 < len(valueFilter[conditionGroupKey]) && !s.matchGroup(valueFilter[conditionGroupKey], conditionGroup, entity.Value) {
+		return false
+	}
+	// than context
+	if 0 < len(contextFilter[conditionGroupKey]) && !s.matchGroup(contextFilter[conditionGroupKey], conditionGroup, entity.Context) {
+		return false
+	}
+	// and now the properties
+	for propertyKey, propertyConditions := range propertyList[conditionGroupKey] {
+		value, ok := entity.Properties[propertyKey]
+		if !ok {
+			// property does not exist so only notexists can match
+			if !matchMissing(propertyConditions, conditionGroup) {
+				return false
+			}
+			continue
+		}
+		if !s.matchGroup(propertyConditions, conditionGroup, value) {
+			return false
+		}
+	}
+	// if we are still in here all the applied filters worked
+	return true
+}
+
+// checks if all of the conditions match a missing field
+func matchMissing(filterGroup []int, conditions [][3]string) bool {
+	for _, filterGroupID := range filterGroup {
+		if "notexists" != conditions[filterGroupID][1] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,352 @@
+package storage
+
+import (
+	"errors"
+	"github.com/voodooEntity/gits/src/types"
+	"sort"
+)
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// enable or disable dag enforcement for the whole storage.
+// while active CreateRelation and LinkAddressLists reject
+// any relation that would close a cycle
+func (s *Storage) SetDagMode(active bool) {
+	s.EntityTypeMutex.Lock()
+	s.DagMode = active
+	s.EntityTypeMutex.Unlock()
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// enable or disable dag enforcement for a single entity type.
+// a relation is checked if source and target type are both
+// enforced and only relations between enforced types are
+// followed to detect a cycle
+func (s *Storage) SetDagModeByType(typeID int, active bool) error {
+	s.EntityTypeMutex.Lock()
+	if _, ok := s.EntityTypes[typeID]; !ok {
+		s.EntityTypeMutex.Unlock()
+		return errors.New("Entity Type not existing")
+	}
+	if nil == s.DagTypes {
+		s.DagTypes = make(map[int]bool)
+	}
+	if active {
+		s.DagTypes[typeID] = true
+	} else {
+		delete(s.DagTypes, typeID)
+	}
+	s.EntityTypeMutex.Unlock()
+	return nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// returns all cycles found in the storage. each cycle is
+// returned as ordered address list [type, id] in which every
+// entry is the parent of the following one and the last entry
+// is the parent of the first. one cycle is reported per
+// back edge found, so the list covers every cyclic part of
+// the graph but not every possible elementary cycle.
+// typeFilter limits the search to the given entity types,
+// if empty all types are searched
+func (s *Storage) FindCycles(typeFilter []string) [][][2]int {
+	s.EntityTypeMutex.RLock()
+	s.EntityStorageMutex.RLock()
+	s.RelationStorageMutex.RLock()
+	ret := s.FindCyclesUnsafe(typeFilter)
+	s.RelationStorageMutex.RUnlock()
+	s.EntityStorageMutex.RUnlock()
+	s.EntityTypeMutex.RUnlock()
+	return ret
+}
+
+func (s *Storage) FindCyclesUnsafe(typeFilter []string) [][][2]int {
+	allowedTypes := s.getTypeFilterUnsafe(typeFilter)
+
+	// 0 = unvisited, 1 = on current path, 2 = done
+	state := make(map[[2]int]int)
+	pathIndex := make(map[[2]int]int)
+	var path [][2]int
+	var cycles [][][2]int
+
+	var visit func(address [2]int)
+	visit = func(address [2]int) {
+		state[address] = 1
+		pathIndex[address] = len(path)
+		path = append(path, address)
+		for _, next := range s.getChildAddressesUnsafe(address, allowedTypes) {
+			switch state[next] {
+			case 0:
+				visit(next)
+			case 1:
+				// we found a back edge, everything on the path
+				// from next to the current address is a cycle
+				cycle := make([][2]int, len(path)-pathIndex[next])
+				copy(cycle, path[pathIndex[next]:])
+				cycles = append(cycles, cycle)
+			}
+		}
+		path = path[:len(path)-1]
+		delete(pathIndex, address)
+		state[address] = 2
+	}
+
+	for _, address := range s.getAddressesUnsafe(allowedTypes) {
+		if 0 == state[address] {
+			visit(address)
+		}
+	}
+
+	return cycles
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// returns all entities of the given type (filtered by context
+// if given) ordered so that every entity comes after all its
+// parents of the same type. entities without dependencies
+// between each other are ordered by their id. returns an
+// error if the type does not exist or contains a cycle
+func (s *Storage) TopologicalOrder(Type string, context string) ([]types.StorageEntity, error) {
+	s.EntityTypeMutex.RLock()
+	s.EntityStorageMutex.RLock()
+	s.RelationStorageMutex.RLock()
+	ret, err := s.TopologicalOrderUnsafe(Type, context)
+	s.RelationStorageMutex.RUnlock()
+	s.EntityStorageMutex.RUnlock()
+	s.EntityTypeMutex.RUnlock()
+	return ret, err
+}
+
+func (s *Storage) TopologicalOrderUnsafe(Type string, context string) ([]types.StorageEntity, error) {
+	typeID, err := s.GetTypeIdByStringUnsafe(Type)
+	if nil != err {
+		return nil, err
+	}
+	entityIDs := s.getDependencyIDsUnsafe(typeID, context)
+
+	// count the parents of every entity inside of our pool
+	inDegree := make(map[int]int)
+	var ready []int
+	for _, entityID := range entityIDs {
+		for _, parent := range s.getParentAddressesUnsafe([2]int{typeID, entityID}, map[int]bool{typeID: true}) {
+			if s.isDependencyUnsafe(typeID, parent[1], context) {
+				inDegree[entityID]++
+			}
+		}
+		if _, ok := inDegree[entityID]; !ok {
+			inDegree[entityID] = 0
+			ready = append(ready, entityID)
+		}
+	}
+
+	// now we walk through the entities that have no unprocessed parents
+	// left, always picking the lowest id to keep the order stable
+	var ret []types.StorageEntity
+	for 0 < len(ready) {
+		entityID := ready[0]
+		ready = ready[1:]
+		ret = append(ret, s.deepCopyEntity(s.EntityStorage[typeID][entityID]))
+		for _, child := range s.getChildAddressesUnsafe([2]int{typeID, entityID}, map[int]bool{typeID: true}) {
+			if _, ok := inDegree[child[1]]; !ok {
+				continue
+			}
+			inDegree[child[1]]--
+			if 0 == inDegree[child[1]] {
+				position := sort.SearchInts(ready, child[1])
+				ready = append(ready, 0)
+				copy(ready[position+1:], ready[position:])
+				ready[position] = child[1]
+			}
+		}
+	}
+
+	if len(ret) != len(entityIDs) {
+		return nil, errors.New("Entities of given type contain a cycle")
+	}
+	return ret, nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// returns all entities of the given type (filtered by context if
+// given) that are ready to be processed. an entity is ready if it does
+// not fulfill the condition itself while all of its parents of the same
+// type do. the condition is given as [field, operator, value] the same
+// way as in query Match() e.g. {"Properties.state", "==", "done"}
+func (s *Storage) GetReadyEntities(Type string, context string, condition [3]string) ([]types.StorageEntity, error) {
+	s.EntityTypeMutex.RLock()
+	s.EntityStorageMutex.RLock()
+	s.RelationStorageMutex.RLock()
+	ret, err := s.GetReadyEntitiesUnsafe(Type, context, condition)
+	s.RelationStorageMutex.RUnlock()
+	s.EntityStorageMutex.RUnlock()
+	s.EntityTypeMutex.RUnlock()
+	return ret, err
+}
+
+func (s *Storage) GetReadyEntitiesUnsafe(Type string, context string, condition [3]string) ([]types.StorageEntity, error) {
+	typeID, err := s.GetTypeIdByStringUnsafe(Type)
+	if nil != err {
+		return nil, err
+	}
+
+	fulfills := func(entity types.StorageEntity) bool {
+		field, _ := SplitConditionField(condition[0])
+		value, ok := s.getEntityField(entity, field)
+		if !ok {
+			return "notexists" == condition[1]
+		}
+		return s.matchCondition(value, condition)
+	}
+
+	var ret []types.StorageEntity
+	for _, entityID := range s.getDependencyIDsUnsafe(typeID, context) {
+		entity := s.EntityStorage[typeID][entityID]
+		if fulfills(entity) {
+			continue
+		}
+		ready := true
+		for _, parent := range s.getParentAddressesUnsafe([2]int{typeID, entityID}, map[int]bool{typeID: true}) {
+			if s.isDependencyUnsafe(typeID, parent[1], context) && !fulfills(s.EntityStorage[typeID][parent[1]]) {
+				ready = false
+				break
+			}
+		}
+		if ready {
+			ret = append(ret, s.deepCopyEntity(entity))
+		}
+	}
+	return ret, nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// + + + + + + + + + +  PRIVATE  + + + + + + + + + + +
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// checks if dag enforcement applies to a relation between
+// the given types and if so, if the relation would close a cycle.
+// expects EntityTypeMutex and RelationStorageMutex to be locked
+func (s *Storage) relationClosesCycleUnsafe(srcType int, srcID int, targetType int, targetID int) bool {
+	if !s.DagMode && !(s.DagTypes[srcType] && s.DagTypes[targetType]) {
+		return false
+	}
+
+	// an entity pointing to itself always is a cycle
+	source := [2]int{srcType, srcID}
+	if srcType == targetType && srcID == targetID {
+		return true
+	}
+
+	// if the source is reachable from the target the
+	// new relation would close the circle
+	visited := map[[2]int]bool{{targetType, targetID}: true}
+	stack := [][2]int{{targetType, targetID}}
+	for 0 < len(stack) {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for childType, childIDs := range s.RelationStorage[current[0]][current[1]] {
+			if !s.DagMode && !s.DagTypes[childType] {
+				continue
+			}
+			for childID := range childIDs {
+				child := [2]int{childType, childID}
+				if child == source {
+					return true
+				}
+				if !visited[child] {
+					visited[child] = true
+					stack = append(stack, child)
+				}
+			}
+		}
+	}
+	return false
+}
+
+// translates a list of type names into a set of type ids,
+// an empty filter results in all existing types
+func (s *Storage) getTypeFilterUnsafe(typeFilter []string) map[int]bool {
+	allowedTypes := make(map[int]bool)
+	if 0 == len(typeFilter) {
+		for typeID := range s.EntityTypes {
+			allowedTypes[typeID] = true
+		}
+		return allowedTypes
+	}
+	for _, typeName := range typeFilter {
+		if typeID, ok := s.EntityRTypes[typeName]; ok {
+			allowedTypes[typeID] = true
+		}
+	}
+	return allowedTypes
+}
+
+// returns the addresses of all entities of the given types
+// sorted by type and id to keep results reproducible
+func (s *Storage) getAddressesUnsafe(allowedTypes map[int]bool) [][2]int {
+	var ret [][2]int
+	for typeID := range allowedTypes {
+		for entityID := range s.EntityStorage[typeID] {
+			ret = append(ret, [2]int{typeID, entityID})
+		}
+	}
+	sortAddresses(ret)
+	return ret
+}
+
+// returns the sorted addresses of all children of the given address
+// which are of an allowed type. a nil type set allows all types
+func (s *Storage) getChildAddressesUnsafe(address [2]int, allowedTypes map[int]bool) [][2]int {
+	var ret [][2]int
+	for childType, childIDs := range s.RelationStorage[address[0]][address[1]] {
+		if nil != allowedTypes && !allowedTypes[childType] {
+			continue
+		}
+		for childID := range childIDs {
+			ret = append(ret, [2]int{childType, childID})
+		}
+	}
+	sortAddresses(ret)
+	return ret
+}
+
+// returns the sorted addresses of all parents of the given address
+// which are of an allowed type. a nil type set allows all types
+func (s *Storage) getParentAddressesUnsafe(address [2]int, allowedTypes map[int]bool) [][2]int {
+	var ret [][2]int
+	for parentType, parentIDs := range s.RelationRStorage[address[0]][address[1]] {
+		if nil != allowedTypes && !allowedTypes[parentType] {
+			continue
+		}
+		for parentID := range parentIDs {
+			ret = append(ret, [2]int{parentType, parentID})
+		}
+	}
+	sortAddresses(ret)
+	return ret
+}
+
+// returns the sorted ids of all entities of the given type
+// that are part of a dependency graph with the given context
+func (s *Storage) getDependencyIDsUnsafe(typeID int, context string) []int {
+	var ret []int
+	for entityID := range s.EntityStorage[typeID] {
+		if s.isDependencyUnsafe(typeID, entityID, context) {
+			ret = append(ret, entityID)
+		}
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+func (s *Storage) isDependencyUnsafe(typeID int, entityID int, context string) bool {
+	entity, ok := s.EntityStorage[typeID][entityID]
+	return ok && ("" == context || entity.Context == context)
+}
+
+func sortAddresses(addresses [][2]int) {
+	sort.Slice(addresses, func(i, j int) bool {
+		if addresses[i][0] != addresses[j][0] {
+			return addresses[i][0] < addresses[j][0]
+		}
+		return addresses[i][1] < addresses[j][1]
+	})
+}
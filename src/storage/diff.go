@@ -0,0 +1,344 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/voodooEntity/gits/src/types"
+)
+
+// identities used to match entities between two storages
+const (
+	// entities are matched by type name and id
+	DIFF_BY_ID = 0
+	// entities are matched by type name, value and context
+	DIFF_BY_VALUE_CONTEXT = 1
+)
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// the changes needed to turn one storage into another. entities are
+// referenced by type name so a change set can be serialized as json
+// and applied to any storage regardless of its type ids
+type ChangeSet struct {
+	Identity         int
+	AddedEntities    []ChangeEntity
+	RemovedEntities  []ChangeEntity
+	ChangedEntities  []ChangeEntity
+	AddedRelations   []ChangeRelation
+	RemovedRelations []ChangeRelation
+	ChangedRelations []ChangeRelation
+}
+
+// an entity in a change set. changed entities hold their new state
+type ChangeEntity struct {
+	Type       string
+	ID         int
+	Value      string
+	Context    string
+	Properties map[string]string
+}
+
+// a relation in a change set, source and target only hold the
+// fields needed to identify the related entities. changed
+// relations hold their new state
+type ChangeRelation struct {
+	Source     ChangeEntity
+	Target     ChangeEntity
+	Context    string
+	Properties map[string]string
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// returns the changes needed to turn storage a into storage b.
+// identity defines how entities are matched between both storages.
+// using DIFF_BY_VALUE_CONTEXT and multiple entities of a type share
+// value and context, only the one with the lowest id is considered
+func Diff(a *Storage, b *Storage, identity int) ChangeSet {
+	// work on copies so we never lock both storages at once
+	alpha := a.snapshot()
+	beta := b.snapshot()
+
+	cs := ChangeSet{Identity: identity}
+	alphaEntities := alpha.getChangeEntities(identity)
+	betaEntities := beta.getChangeEntities(identity)
+	for _, key := range sortedEntityKeys(alphaEntities) {
+		if _, ok := betaEntities[key]; !ok {
+			cs.RemovedEntities = append(cs.RemovedEntities, alphaEntities[key])
+		}
+	}
+	for _, key := range sortedEntityKeys(betaEntities) {
+		betaEntity := betaEntities[key]
+		alphaEntity, ok := alphaEntities[key]
+		if !ok {
+			cs.AddedEntities = append(cs.AddedEntities, betaEntity)
+			continue
+		}
+		if alphaEntity.Value != betaEntity.Value || alphaEntity.Context != betaEntity.Context || !equalProperties(alphaEntity.Properties, betaEntity.Properties) {
+			cs.ChangedEntities = append(cs.ChangedEntities, betaEntity)
+		}
+	}
+
+	alphaRelations := alpha.getChangeRelations(identity, alphaEntities)
+	betaRelations := beta.getChangeRelations(identity, betaEntities)
+	for _, key := range sortedRelationKeys(alphaRelations) {
+		if _, ok := betaRelations[key]; !ok {
+			cs.RemovedRelations = append(cs.RemovedRelations, alphaRelations[key])
+		}
+	}
+	for _, key := range sortedRelationKeys(betaRelations) {
+		betaRelation := betaRelations[key]
+		alphaRelation, ok := alphaRelations[key]
+		if !ok {
+			cs.AddedRelations = append(cs.AddedRelations, betaRelation)
+			continue
+		}
+		if alphaRelation.Context != betaRelation.Context || !equalProperties(alphaRelation.Properties, betaRelation.Properties) {
+			cs.ChangedRelations = append(cs.ChangedRelations, betaRelation)
+		}
+	}
+	return cs
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// applies a change set to the target storage. removals are applied
+// first, followed by added and changed entities and relations. missing
+// entity types are created. using DIFF_BY_ID added entities keep their id.
+// changes that can't be applied, e.g. because the entity to change does
+// not exist, are skipped and reported by the returned error
+func ApplyChangeSet(target *Storage, cs ChangeSet) error {
+	target.EntityTypeMutex.Lock()
+	target.EntityStorageMutex.Lock()
+	target.RelationStorageMutex.Lock()
+	err := target.ApplyChangeSetUnsafe(cs)
+	target.RelationStorageMutex.Unlock()
+	target.EntityStorageMutex.Unlock()
+	target.EntityTypeMutex.Unlock()
+	return err
+}
+
+func (s *Storage) ApplyChangeSetUnsafe(cs ChangeSet) error {
+	failed := 0
+	index := s.getChangeIndexUnsafe(cs.Identity)
+	resolve := func(entity ChangeEntity) ([2]int, bool) {
+		address, ok := index[getChangeKey(entity, cs.Identity)]
+		return address, ok
+	}
+
+	for _, relation := range cs.RemovedRelations {
+		source, sourceOk := resolve(relation.Source)
+		target, targetOk := resolve(relation.Target)
+		if !sourceOk || !targetOk || !s.RelationExistsUnsafe(source[0], source[1], target[0], target[1]) {
+			failed++
+			continue
+		}
+		s.DeleteRelationUnsafe(source[0], source[1], target[0], target[1])
+	}
+
+	for _, entity := range cs.RemovedEntities {
+		address, ok := resolve(entity)
+		if !ok {
+			failed++
+			continue
+		}
+		s.DeleteEntityUnsafe(address[0], address[1])
+		delete(index, getChangeKey(entity, cs.Identity))
+	}
+
+	for _, entity := range cs.AddedEntities {
+		key := getChangeKey(entity, cs.Identity)
+		if _, ok := index[key]; ok {
+			failed++
+			continue
+		}
+		typeID, _ := s.CreateEntityTypeUnsafe(entity.Type)
+		newEntity := types.StorageEntity{
+			Type:       typeID,
+			Value:      entity.Value,
+			Context:    entity.Context,
+			Properties: copyProperties(entity.Properties),
+		}
+		if DIFF_BY_ID == cs.Identity {
+			newEntity.ID = entity.ID
+			newEntity.Version = 1
+			s.insertEntityUnsafe(newEntity)
+		} else {
+			newEntity.ID, _ = s.CreateEntityUnsafe(newEntity)
+		}
+		index[key] = [2]int{typeID, newEntity.ID}
+	}
+
+	for _, entity := range cs.ChangedEntities {
+		address, ok := resolve(entity)
+		if !ok {
+			failed++
+			continue
+		}
+		existing := s.deepCopyEntity(s.EntityStorage[address[0]][address[1]])
+		existing.Value = entity.Value
+		existing.Context = entity.Context
+		existing.Properties = copyProperties(entity.Properties)
+		s.UpdateEntityUnsafe(existing)
+	}
+
+	for _, relation := range cs.AddedRelations {
+		source, sourceOk := resolve(relation.Source)
+		target, targetOk := resolve(relation.Target)
+		if !sourceOk || !targetOk {
+			failed++
+			continue
+		}
+		created, _ := s.CreateRelationUnsafe(source[0], source[1], target[0], target[1], types.StorageRelation{
+			SourceType: source[0],
+			SourceID:   source[1],
+			TargetType: target[0],
+			TargetID:   target[1],
+			Context:    relation.Context,
+			Properties: copyProperties(relation.Properties),
+		})
+		if !created {
+			failed++
+		}
+	}
+
+	for _, relation := range cs.ChangedRelations {
+		source, sourceOk := resolve(relation.Source)
+		target, targetOk := resolve(relation.Target)
+		if !sourceOk || !targetOk {
+			failed++
+			continue
+		}
+		existing, err := s.GetRelationUnsafe(source[0], source[1], target[0], target[1])
+		if nil != err {
+			failed++
+			continue
+		}
+		existing.Context = relation.Context
+		existing.Properties = copyProperties(relation.Properties)
+		s.UpdateRelationUnsafe(source[0], source[1], target[0], target[1], existing)
+	}
+
+	if 0 < failed {
+		return errors.New(strconv.Itoa(failed) + " changes could not be applied")
+	}
+	return nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// + + + + + + + + + +  PRIVATE  + + + + + + + + + + +
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// returns all entities keyed by their identity
+func (s *Storage) getChangeEntities(identity int) map[string]ChangeEntity {
+	ret := make(map[string]ChangeEntity)
+	for _, address := range s.getAddressesUnsafe(s.getTypeFilterUnsafe(nil)) {
+		entity := s.EntityStorage[address[0]][address[1]]
+		changeEntity := ChangeEntity{
+			Type:       s.EntityTypes[entity.Type],
+			ID:         entity.ID,
+			Value:      entity.Value,
+			Context:    entity.Context,
+			Properties: copyProperties(entity.Properties),
+		}
+		key := getChangeKey(changeEntity, identity)
+		// addresses are sorted so the lowest id wins
+		if _, ok := ret[key]; !ok {
+			ret[key] = changeEntity
+		}
+	}
+	return ret
+}
+
+// returns all relations keyed by the identity of source and target
+func (s *Storage) getChangeRelations(identity int, entities map[string]ChangeEntity) map[string]ChangeRelation {
+	ret := make(map[string]ChangeRelation)
+	for _, address := range s.getAddressesUnsafe(s.getTypeFilterUnsafe(nil)) {
+		source := s.getChangeReference(address, identity)
+		// entities shadowed by another one with the same identity are skipped
+		if entities[getChangeKey(source, identity)].ID != address[1] {
+			continue
+		}
+		for _, child := range s.getChildAddressesUnsafe(address, nil) {
+			target := s.getChangeReference(child, identity)
+			if entities[getChangeKey(target, identity)].ID != child[1] {
+				continue
+			}
+			relation := s.RelationStorage[address[0]][address[1]][child[0]][child[1]]
+			ret[getChangeKey(source, identity)+"\x01"+getChangeKey(target, identity)] = ChangeRelation{
+				Source:     source,
+				Target:     target,
+				Context:    relation.Context,
+				Properties: copyProperties(relation.Properties),
+			}
+		}
+	}
+	return ret
+}
+
+// returns the identifying fields of an entity
+func (s *Storage) getChangeReference(address [2]int, identity int) ChangeEntity {
+	ret := ChangeEntity{Type: s.EntityTypes[address[0]]}
+	if DIFF_BY_VALUE_CONTEXT == identity {
+		ret.Value = s.EntityStorage[address[0]][address[1]].Value
+		ret.Context = s.EntityStorage[address[0]][address[1]].Context
+	} else {
+		ret.ID = address[1]
+	}
+	return ret
+}
+
+// returns the addresses of all entities keyed by their identity
+func (s *Storage) getChangeIndexUnsafe(identity int) map[string][2]int {
+	ret := make(map[string][2]int)
+	for _, address := range s.getAddressesUnsafe(s.getTypeFilterUnsafe(nil)) {
+		key := getChangeKey(s.getChangeReference(address, identity), identity)
+		if _, ok := ret[key]; !ok {
+			ret[key] = address
+		}
+	}
+	return ret
+}
+
+// stores an entity with its given id, used if ids have to be kept
+func (s *Storage) insertEntityUnsafe(entity types.StorageEntity) {
+	s.EntityStorage[entity.Type][entity.ID] = entity
+	s.recordEntityUnsafe(entity)
+	s.RelationStorage[entity.Type][entity.ID] = make(map[int]map[int]types.StorageRelation)
+	s.RelationRStorage[entity.Type][entity.ID] = make(map[int]map[int]bool)
+	if entity.ID > s.EntityIDMax[entity.Type] {
+		s.EntityIDMax[entity.Type] = entity.ID
+	}
+}
+
+func getChangeKey(entity ChangeEntity, identity int) string {
+	if DIFF_BY_VALUE_CONTEXT == identity {
+		return entity.Type + "\x00" + entity.Value + "\x00" + entity.Context
+	}
+	return entity.Type + "\x00" + strconv.Itoa(entity.ID)
+}
+
+func sortedEntityKeys(entities map[string]ChangeEntity) []string {
+	keys := make([]string, 0, len(entities))
+	for key := range entities {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedRelationKeys(relations map[string]ChangeRelation) []string {
+	keys := make([]string, 0, len(relations))
+	for key := range relations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func copyProperties(properties map[string]string) map[string]string {
+	ret := make(map[string]string, len(properties))
+	for key, value := range properties {
+		ret[key] = value
+	}
+	return ret
+}
@@ -0,0 +1,364 @@
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gits/src/types"
+)
+
+// defines how many old versions are kept for a type with history
+// enabled. if both limits are set a version has to fulfill both to
+// be kept, the current version of an entity is always kept
+type HistoryConfig struct {
+	// amount of versions kept per entity or relation including
+	// the current one, 0 means unlimited
+	MaxVersions int
+	// how long a replaced or deleted version is kept, 0 means unlimited
+	MaxAge time.Duration
+}
+
+// a version of an entity and the time range it was the current
+// one in. Until is zero if the version still is the current one
+type EntityVersion struct {
+	Entity types.StorageEntity
+	From   time.Time
+	Until  time.Time
+}
+
+// same as EntityVersion for relations
+type RelationVersion struct {
+	Relation types.StorageRelation
+	From     time.Time
+	Until    time.Time
+}
+
+// used to retrieve the time versions are recorded at
+var historyNow = time.Now
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// enables the version history for an entity type. from now on every
+// version of entities of the type and their relations are kept within
+// the limits of the config. entities and relations existing at this
+// point are recorded as valid since ever. enabling an already enabled
+// type only changes its config
+func (s *Storage) EnableHistory(typeID int, config HistoryConfig) error {
+	s.EntityTypeMutex.Lock()
+	s.EntityStorageMutex.Lock()
+	s.RelationStorageMutex.Lock()
+	defer s.RelationStorageMutex.Unlock()
+	defer s.EntityStorageMutex.Unlock()
+	defer s.EntityTypeMutex.Unlock()
+
+	if _, ok := s.EntityTypes[typeID]; !ok {
+		return errors.New("Entity Type not existing")
+	}
+	s.HistoryTypes[typeID] = config
+
+	for entityID, entity := range s.EntityStorage[typeID] {
+		address := [2]int{typeID, entityID}
+		if _, ok := s.EntityHistory[address]; !ok {
+			s.EntityHistory[address] = []EntityVersion{{Entity: s.deepCopyEntity(entity)}}
+		}
+		for _, child := range s.getChildAddressesUnsafe(address, nil) {
+			s.seedRelationHistoryUnsafe([4]int{typeID, entityID, child[0], child[1]})
+		}
+		for _, parent := range s.getParentAddressesUnsafe(address, nil) {
+			s.seedRelationHistoryUnsafe([4]int{parent[0], parent[1], typeID, entityID})
+		}
+	}
+	return nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// disables the version history for an entity type and drops
+// the history recorded for it
+func (s *Storage) DisableHistory(typeID int) {
+	s.EntityTypeMutex.Lock()
+	s.EntityStorageMutex.Lock()
+	s.RelationStorageMutex.Lock()
+	defer s.RelationStorageMutex.Unlock()
+	defer s.EntityStorageMutex.Unlock()
+	defer s.EntityTypeMutex.Unlock()
+
+	delete(s.HistoryTypes, typeID)
+	for address := range s.EntityHistory {
+		if typeID == address[0] {
+			delete(s.EntityHistory, address)
+		}
+	}
+	for address := range s.RelationHistory {
+		if _, ok := s.getRelationHistoryConfigUnsafe(address); !ok {
+			delete(s.RelationHistory, address)
+		}
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// returns an entity in the state of the given version. only
+// the current version is available for types without history
+func (s *Storage) GetEntityAtVersion(Type int, id int, version int) (types.StorageEntity, error) {
+	s.EntityStorageMutex.RLock()
+	ret, err := s.GetEntityAtVersionUnsafe(Type, id, version)
+	s.EntityStorageMutex.RUnlock()
+	return ret, err
+}
+
+func (s *Storage) GetEntityAtVersionUnsafe(Type int, id int, version int) (types.StorageEntity, error) {
+	if entity, ok := s.EntityStorage[Type][id]; ok && entity.Version == version {
+		return s.deepCopyEntity(entity), nil
+	}
+	for _, entityVersion := range s.EntityHistory[[2]int{Type, id}] {
+		if entityVersion.Entity.Version == version {
+			return s.deepCopyEntity(entityVersion.Entity), nil
+		}
+	}
+	return types.StorageEntity{}, errors.New("Entity version not available")
+}
+
+// returns the selected fields of an entity in the state of the given
+// version, see selectEntityFieldsUnsafe. expects the EntityTypeMutex
+// and EntityStorageMutex to be locked
+func (s *Storage) GetTransportEntityAtVersionUnsafe(Type int, id int, version int, selection ...string) (transport.TransportEntity, error) {
+	entity, err := s.GetEntityAtVersionUnsafe(Type, id, version)
+	if nil != err {
+		return transport.TransportEntity{}, err
+	}
+	return s.selectEntityFieldsUnsafe(entity, selection), nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// returns an entity in the state it had at the given time. for
+// types without history the current state is returned
+func (s *Storage) GetEntityAsOf(Type int, id int, t time.Time) (types.StorageEntity, error) {
+	s.EntityStorageMutex.RLock()
+	ret, err := s.GetEntityAsOfUnsafe(Type, id, t)
+	s.EntityStorageMutex.RUnlock()
+	return ret, err
+}
+
+func (s *Storage) GetEntityAsOfUnsafe(Type int, id int, t time.Time) (types.StorageEntity, error) {
+	if _, ok := s.HistoryTypes[Type]; !ok {
+		if entity, ok := s.EntityStorage[Type][id]; ok {
+			return s.deepCopyEntity(entity), nil
+		}
+		return types.StorageEntity{}, errors.New("Entity not existing")
+	}
+	for _, entityVersion := range s.EntityHistory[[2]int{Type, id}] {
+		if isActiveAt(entityVersion.From, entityVersion.Until, t) {
+			return s.deepCopyEntity(entityVersion.Entity), nil
+		}
+	}
+	return types.StorageEntity{}, errors.New("Entity not existing at given time")
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// returns a copy of the storage in the state it had at the given time.
+// entities and relations of types without history are copied in their
+// current state. relations are only part of the copy if both related
+// entities are
+func (s *Storage) SnapshotAsOf(t time.Time) *Storage {
+	s.EntityTypeMutex.RLock()
+	s.EntityStorageMutex.RLock()
+	s.RelationStorageMutex.RLock()
+	ret := s.SnapshotAsOfUnsafe(t)
+	s.RelationStorageMutex.RUnlock()
+	s.EntityStorageMutex.RUnlock()
+	s.EntityTypeMutex.RUnlock()
+	return ret
+}
+
+func (s *Storage) SnapshotAsOfUnsafe(t time.Time) *Storage {
+	ret := s.newHistorySnapshotUnsafe()
+
+	for typeID := range s.EntityTypes {
+		if _, ok := s.HistoryTypes[typeID]; !ok {
+			for _, entity := range s.EntityStorage[typeID] {
+				ret.insertEntityUnsafe(s.deepCopyEntity(entity))
+			}
+		}
+	}
+	for _, versions := range s.EntityHistory {
+		for _, entityVersion := range versions {
+			if isActiveAt(entityVersion.From, entityVersion.Until, t) {
+				ret.insertEntityUnsafe(s.deepCopyEntity(entityVersion.Entity))
+			}
+		}
+	}
+
+	for address, versions := range s.RelationHistory {
+		for _, relationVersion := range versions {
+			if isActiveAt(relationVersion.From, relationVersion.Until, t) {
+				ret.insertRelationUnsafe(address, s.deepCopyRelation(relationVersion.Relation))
+			}
+		}
+	}
+	s.copyRelationsUnsafe(ret, true)
+	return ret
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// + + + + + + + + + +  PRIVATE  + + + + + + + + + + +
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// records a new current version of an entity.
+// expects the EntityStorageMutex to be locked
+func (s *Storage) recordEntityUnsafe(entity types.StorageEntity) {
+	config, ok := s.HistoryTypes[entity.Type]
+	if !ok {
+		return
+	}
+	now := historyNow()
+	address := [2]int{entity.Type, entity.ID}
+	versions := s.EntityHistory[address]
+	if last := len(versions) - 1; 0 <= last && versions[last].Until.IsZero() {
+		versions[last].Until = now
+	}
+	versions = append(versions, EntityVersion{Entity: s.deepCopyEntity(entity), From: now})
+	s.EntityHistory[address] = versions[getHistoryStart(len(versions), func(i int) time.Time { return versions[i].Until }, config, now):]
+}
+
+// marks the current version of an entity as deleted.
+// expects the EntityStorageMutex to be locked
+func (s *Storage) recordEntityDeleteUnsafe(Type int, id int) {
+	config, ok := s.HistoryTypes[Type]
+	if !ok {
+		return
+	}
+	now := historyNow()
+	address := [2]int{Type, id}
+	versions := s.EntityHistory[address]
+	if last := len(versions) - 1; 0 <= last && versions[last].Until.IsZero() {
+		versions[last].Until = now
+	}
+	versions = versions[getHistoryStart(len(versions), func(i int) time.Time { return versions[i].Until }, config, now):]
+	if 0 == len(versions) {
+		delete(s.EntityHistory, address)
+		return
+	}
+	s.EntityHistory[address] = versions
+}
+
+// records a new current version of a relation.
+// expects the RelationStorageMutex to be locked
+func (s *Storage) recordRelationUnsafe(srcType int, srcID int, targetType int, targetID int, relation types.StorageRelation) {
+	address := [4]int{srcType, srcID, targetType, targetID}
+	config, ok := s.getRelationHistoryConfigUnsafe(address)
+	if !ok {
+		return
+	}
+	now := historyNow()
+	versions := s.RelationHistory[address]
+	if last := len(versions) - 1; 0 <= last && versions[last].Until.IsZero() {
+		versions[last].Until = now
+	}
+	versions = append(versions, RelationVersion{Relation: s.deepCopyRelation(relation), From: now})
+	s.RelationHistory[address] = versions[getHistoryStart(len(versions), func(i int) time.Time { return versions[i].Until }, config, now):]
+}
+
+// marks the current version of a relation as deleted.
+// expects the RelationStorageMutex to be locked
+func (s *Storage) recordRelationDeleteUnsafe(srcType int, srcID int, targetType int, targetID int) {
+	address := [4]int{srcType, srcID, targetType, targetID}
+	config, ok := s.getRelationHistoryConfigUnsafe(address)
+	if !ok {
+		return
+	}
+	now := historyNow()
+	versions := s.RelationHistory[address]
+	if last := len(versions) - 1; 0 <= last && versions[last].Until.IsZero() {
+		versions[last].Until = now
+	}
+	versions = versions[getHistoryStart(len(versions), func(i int) time.Time { return versions[i].Until }, config, now):]
+	if 0 == len(versions) {
+		delete(s.RelationHistory, address)
+		return
+	}
+	s.RelationHistory[address] = versions
+}
+
+// relations are recorded if the source or target type has history
+// enabled, the config of the source type is preferred
+func (s *Storage) getRelationHistoryConfigUnsafe(address [4]int) (HistoryConfig, bool) {
+	if config, ok := s.HistoryTypes[address[0]]; ok {
+		return config, true
+	}
+	config, ok := s.HistoryTypes[address[2]]
+	return config, ok
+}
+
+func (s *Storage) seedRelationHistoryUnsafe(address [4]int) {
+	if _, ok := s.RelationHistory[address]; ok {
+		return
+	}
+	relation := s.RelationStorage[address[0]][address[1]][address[2]][address[3]]
+	s.RelationHistory[address] = []RelationVersion{{Relation: s.deepCopyRelation(relation)}}
+}
+
+// returns the index of the first version to keep based on the config.
+// a version still being current (until is zero) is always kept
+func getHistoryStart(amount int, until func(i int) time.Time, config HistoryConfig, now time.Time) int {
+	start := 0
+	if 0 < config.MaxVersions && amount > config.MaxVersions {
+		start = amount - config.MaxVersions
+	}
+	if 0 < config.MaxAge {
+		for start < amount && !until(start).IsZero() && now.Sub(until(start)) > config.MaxAge {
+			start++
+		}
+	}
+	return start
+}
+
+func isActiveAt(from time.Time, until time.Time, t time.Time) bool {
+	return !from.After(t) && (until.IsZero() || t.Before(until))
+}
+
+// creates an empty storage holding the same entity types
+func (s *Storage) newHistorySnapshotUnsafe() *Storage {
+	ret := NewStorage()
+	for typeID, name := range s.EntityTypes {
+		ret.EntityTypes[typeID] = name
+		ret.EntityRTypes[name] = typeID
+		ret.EntityStorage[typeID] = make(map[int]types.StorageEntity)
+		ret.RelationStorage[typeID] = make(map[int]map[int]map[int]types.StorageRelation)
+		ret.RelationRStorage[typeID] = make(map[int]map[int]map[int]bool)
+		ret.EntityIDMax[typeID] = s.EntityIDMax[typeID]
+	}
+	ret.EntityTypeIDMax = s.EntityTypeIDMax
+	return ret
+}
+
+// copies the current relations into the snapshot if both related
+// entities exist in it, optionally skipping relations with history
+func (s *Storage) copyRelationsUnsafe(snapshot *Storage, skipHistory bool) {
+	for srcType, sources := range s.RelationStorage {
+		for srcID, targetTypes := range sources {
+			for targetType, targets := range targetTypes {
+				for targetID, relation := range targets {
+					address := [4]int{srcType, srcID, targetType, targetID}
+					if _, ok := s.RelationHistory[address]; ok && skipHistory {
+						continue
+					}
+					snapshot.insertRelationUnsafe(address, s.deepCopyRelation(relation))
+				}
+			}
+		}
+	}
+}
+
+// stores a relation if both related entities exist
+func (s *Storage) insertRelationUnsafe(address [4]int, relation types.StorageRelation) {
+	if !s.EntityExistsUnsafe(address[0], address[1]) || !s.EntityExistsUnsafe(address[2], address[3]) {
+		return
+	}
+	if _, ok := s.RelationStorage[address[0]][address[1]][address[2]]; !ok {
+		s.RelationStorage[address[0]][address[1]][address[2]] = make(map[int]types.StorageRelation)
+	}
+	if _, ok := s.RelationRStorage[address[2]][address[3]][address[0]]; !ok {
+		s.RelationRStorage[address[2]][address[3]][address[0]] = make(map[int]bool)
+	}
+	s.RelationStorage[address[0]][address[1]][address[2]][address[3]] = relation
+	s.RelationRStorage[address[2]][address[3]][address[0]][address[1]] = true
+}
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/voodooEntity/gits/src/types"
+)
+
+// keys used to detect entities existing in both storages
+const (
+	// every imported entity is created new
+	MERGE_KEY_NONE = 0
+	// entities with equal type, value and context are duplicates
+	MERGE_KEY_VALUE_CONTEXT = 1
+	// entities with equal type and value of MergePolicy.Property
+	// are duplicates, entities without the property never are
+	MERGE_KEY_PROPERTY = 2
+)
+
+// modes to resolve duplicates
+const (
+	// existing entities and relations are left untouched
+	MERGE_KEEP_EXISTING = 0
+	// value, context and properties are replaced by the imported ones
+	MERGE_OVERWRITE = 1
+	// imported properties are added to the existing ones,
+	// on conflicting keys the imported value wins
+	MERGE_PROPERTIES = 2
+)
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// defines how duplicates are detected and resolved on Merge
+type MergePolicy struct {
+	Key      int
+	Property string
+	Mode     int
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// imports all entities and relations of other into the storage.
+// entity types are mapped by name and created if needed, imported
+// entities get new ids unless they are detected as duplicate by the
+// policy key, in which case the policy mode defines how they are
+// combined. relations between imported entities that already exist are
+// resolved by the same mode. relations rejected by dag mode are skipped.
+// returns a map translating the addresses of other into local ones
+func (s *Storage) Merge(other *Storage, policy MergePolicy) (map[[2]int][2]int, error) {
+	if s == other {
+		return nil, errors.New("Cant merge a storage into itself")
+	}
+	if MERGE_KEY_PROPERTY == policy.Key && "" == policy.Property {
+		return nil, errors.New("Merge by property requires a property name")
+	}
+
+	// we copy the other storage first so we never hold
+	// locks on both storages at the same time
+	snapshot := other.snapshot()
+
+	s.EntityTypeMutex.Lock()
+	s.EntityStorageMutex.Lock()
+	s.RelationStorageMutex.Lock()
+	ret := s.mergeUnsafe(snapshot, policy)
+	s.RelationStorageMutex.Unlock()
+	s.EntityStorageMutex.Unlock()
+	s.EntityTypeMutex.Unlock()
+	return ret, nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// + + + + + + + + + +  PRIVATE  + + + + + + + + + + +
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// returns a deep copy of the whole storage taken under read locks
+func (s *Storage) snapshot() *Storage {
+	s.EntityTypeMutex.RLock()
+	s.EntityStorageMutex.RLock()
+	s.RelationStorageMutex.RLock()
+	ret, _ := s.ExtractSubgraphUnsafe(s.getAddressesUnsafe(s.getTypeFilterUnsafe(nil)), 0, false)
+	s.RelationStorageMutex.RUnlock()
+	s.EntityStorageMutex.RUnlock()
+	s.EntityTypeMutex.RUnlock()
+	return ret
+}
+
+func (s *Storage) mergeUnsafe(other *Storage, policy MergePolicy) map[[2]int][2]int {
+	// map the entity types by name, missing types are
+	// created in order of their ids in the other storage
+	var otherTypeIDs []int
+	for otherTypeID := range other.EntityTypes {
+		otherTypeIDs = append(otherTypeIDs, otherTypeID)
+	}
+	sort.Ints(otherTypeIDs)
+	typeMap := make(map[int]int)
+	for _, otherTypeID := range otherTypeIDs {
+		typeMap[otherTypeID], _ = s.CreateEntityTypeUnsafe(other.EntityTypes[otherTypeID])
+	}
+
+	// index the existing entities by merge key. if several
+	// entities share a key the lowest id wins
+	keyIndex := make(map[int]map[string]int)
+	for _, typeID := range typeMap {
+		keyIndex[typeID] = make(map[string]int)
+		for entityID, entity := range s.EntityStorage[typeID] {
+			if key, ok := getMergeKey(entity, policy); ok {
+				if indexedID, indexed := keyIndex[typeID][key]; !indexed || entityID < indexedID {
+					keyIndex[typeID][key] = entityID
+				}
+			}
+		}
+	}
+
+	addressMap := make(map[[2]int][2]int)
+	addresses := other.getAddressesUnsafe(other.getTypeFilterUnsafe(nil))
+	for _, address := range addresses {
+		imported := other.deepCopyEntity(other.EntityStorage[address[0]][address[1]])
+		imported.Type = typeMap[address[0]]
+
+		key, hasKey := getMergeKey(imported, policy)
+		if existingID, ok := keyIndex[imported.Type][key]; hasKey && ok {
+			existing := s.deepCopyEntity(s.EntityStorage[imported.Type][existingID])
+			if mergeEntity(&existing, imported, policy.Mode) {
+				s.UpdateEntityUnsafe(existing)
+			}
+			addressMap[address] = [2]int{imported.Type, existingID}
+			continue
+		}
+
+		newID, _ := s.CreateEntityUnsafe(imported)
+		addressMap[address] = [2]int{imported.Type, newID}
+		// duplicates inside of the imported data are merged too
+		if hasKey {
+			keyIndex[imported.Type][key] = newID
+		}
+	}
+
+	for _, source := range addresses {
+		target := addressMap[source]
+		for _, child := range other.getChildAddressesUnsafe(source, nil) {
+			mappedChild := addressMap[child]
+			imported := other.deepCopyRelation(other.RelationStorage[source[0]][source[1]][child[0]][child[1]])
+			imported.SourceType, imported.SourceID = target[0], target[1]
+			imported.TargetType, imported.TargetID = mappedChild[0], mappedChild[1]
+
+			if existing, err := s.GetRelationUnsafe(target[0], target[1], mappedChild[0], mappedChild[1]); nil == err {
+				existing = s.deepCopyRelation(existing)
+				if mergeRelation(&existing, imported, policy.Mode) {
+					s.UpdateRelationUnsafe(target[0], target[1], mappedChild[0], mappedChild[1], existing)
+				}
+				continue
+			}
+			s.CreateRelationUnsafe(target[0], target[1], mappedChild[0], mappedChild[1], imported)
+		}
+	}
+
+	return addressMap
+}
+
+func getMergeKey(entity types.StorageEntity, policy MergePolicy) (string, bool) {
+	switch policy.Key {
+	case MERGE_KEY_VALUE_CONTEXT:
+		return entity.Value + "\x00" + entity.Context, true
+	case MERGE_KEY_PROPERTY:
+		value, ok := entity.Properties[policy.Property]
+		return value, ok
+	}
+	return "", false
+}
+
+// applies the imported data onto the existing entity based on
+// the merge mode. returns if anything changed
+func mergeEntity(existing *types.StorageEntity, imported types.StorageEntity, mode int) bool {
+	switch mode {
+	case MERGE_OVERWRITE:
+		changed := existing.Value != imported.Value || existing.Context != imported.Context || !equalProperties(existing.Properties, imported.Properties)
+		existing.Value = imported.Value
+		existing.Context = imported.Context
+		existing.Properties = imported.Properties
+		return changed
+	case MERGE_PROPERTIES:
+		return mergeProperties(existing.Properties, imported.Properties)
+	}
+	return false
+}
+
+// same as mergeEntity for relations, returns if anything changed
+func mergeRelation(existing *types.StorageRelation, imported types.StorageRelation, mode int) bool {
+	switch mode {
+	case MERGE_OVERWRITE:
+		changed := existing.Context != imported.Context || !equalProperties(existing.Properties, imported.Properties)
+		existing.Context = imported.Context
+		existing.Properties = imported.Properties
+		return changed
+	case MERGE_PROPERTIES:
+		return mergeProperties(existing.Properties, imported.Properties)
+	}
+	return false
+}
+
+// adds all imported properties to the existing map, returns if anything changed
+func mergeProperties(existing map[string]string, imported map[string]string) bool {
+	changed := false
+	for key, value := range imported {
+		if current, ok := existing[key]; !ok || current != value {
+			existing[key] = value
+			changed = true
+		}
+	}
+	return changed
+}
+
+func equalProperties(alpha map[string]string, beta map[string]string) bool {
+	if len(alpha) != len(beta) {
+		return false
+	}
+	for key, value := range alpha {
+		if current, ok := beta[key]; !ok || current != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// operators and transforms registered by the application. they
+// are shared by all storages
+var customOperators = make(map[string]func(alpha string, beta string) bool)
+var customTransforms = make(map[string]func(value string, argument string) (string, bool))
+var customMutex = &sync.RWMutex{}
+
+// names of the operators handled by match
+var builtinOperators = map[string]bool{
+	"==": true, "!=": true, "prefix": true, "suffix": true, "contain": true, "in": true, "regex": true,
+	"i==": true, "i!=": true, "iprefix": true, "isuffix": true, "icontain": true, "iin": true, "iregex": true,
+	">": true, ">=": true, "<": true, "<=": true, "after": true, "before": true, "between": true,
+	"exists": true, "notexists": true,
+}
+
+// transforms which can be applied to the field of a condition
+// using "field|transform" or "field|transform:argument"
+var builtinTransforms = map[string]func(value string, argument string) (string, bool){
+	"lower": transformLower,
+	"len":   transformLen,
+	"json":  transformJSON,
+}
+
+// registers an operator which can be used in conditions like the
+// builtin ones. the operator gets the value of the field as alpha
+// and the compare value as beta. operators are called while the
+// storage is read locked, so they must not call back into the storage
+func RegisterOperator(name string, operator func(alpha string, beta string) bool) error {
+	if "" == name || nil == operator {
+		return errors.New("Invalid operator.")
+	}
+	if builtinOperators[name] {
+		return errors.New("Operator " + name + " is a builtin operator.")
+	}
+	customMutex.Lock()
+	customOperators[name] = operator
+	customMutex.Unlock()
+	return nil
+}
+
+// registers a transform which can be applied to the field of a
+// condition using "field|name" or "field|name:argument". if the
+// transform returns false the condition doesnt match. like operators
+// transforms must not call back into the storage
+func RegisterTransform(name string, transform func(value string, argument string) (string, bool)) error {
+	if "" == name || nil == transform || strings.ContainsAny(name, "|:") {
+		return errors.New("Invalid transform.")
+	}
+	if _, ok := builtinTransforms[name]; ok {
+		return errors.New("Transform " + name + " is a builtin transform.")
+	}
+	customMutex.Lock()
+	customTransforms[name] = transform
+	customMutex.Unlock()
+	return nil
+}
+
+// unregisters an operator, conditions using it dont match anymore
+func UnregisterOperator(name string) {
+	customMutex.Lock()
+	delete(customOperators, name)
+	customMutex.Unlock()
+}
+
+// unregisters a transform, conditions using it dont match anymore
+func UnregisterTransform(name string) {
+	customMutex.Lock()
+	delete(customTransforms, name)
+	customMutex.Unlock()
+}
+
+// splits the alpha of a condition into the field and the transforms
+// applied to it. everything after the first "|" is seen as transforms,
+// so fields containing "|" in their name can't be used in conditions
+func SplitConditionField(alpha string) (string, []string) {
+	parts := strings.Split(alpha, "|")
+	return parts[0], parts[1:]
+}
+
+// applies the transforms of the alpha of a condition to the value.
+// unknown transforms or transforms failing on the value return false
+func applyTransforms(value string, alpha string) (string, bool) {
+	if !strings.Contains(alpha, "|") {
+		return value, true
+	}
+	_, transforms := SplitConditionField(alpha)
+	for _, transform := range transforms {
+		name, argument := transform, ""
+		if index := strings.Index(transform, ":"); -1 != index {
+			name, argument = transform[:index], transform[index+1:]
+		}
+		apply, ok := builtinTransforms[name]
+		if !ok {
+			customMutex.RLock()
+			apply, ok = customTransforms[name]
+			customMutex.RUnlock()
+			if !ok {
+				return "", false
+			}
+		}
+		if value, ok = apply(value, argument); !ok {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+// calls the registered operator, unknown operators never match
+func matchCustomOperator(alpha string, operator string, beta string) bool {
+	customMutex.RLock()
+	apply, ok := customOperators[operator]
+	customMutex.RUnlock()
+	return ok && apply(alpha, beta)
+}
+
+func transformLower(value string, argument string) (string, bool) {
+	return strings.ToLower(value), true
+}
+
+// amount of characters
+func transformLen(value string, argument string) (string, bool) {
+	return strconv.Itoa(utf8.RuneCountInString(value)), true
+}
+
+// returns the element of a json document on the path given as argument.
+// the path consists of object keys and array indexes separated by ".",
+// an empty path returns the whole document. strings are returned as they
+// are, other elements as json. fails if the path doesnt exist
+func transformJSON(value string, argument string) (string, bool) {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	var element interface{}
+	if nil != decoder.Decode(&element) {
+		return "", false
+	}
+	if "" != argument {
+		for _, key := range strings.Split(argument, ".") {
+			switch current := element.(type) {
+			case map[string]interface{}:
+				next, ok := current[key]
+				if !ok {
+					return "", false
+				}
+				element = next
+			case []interface{}:
+				index, err := strconv.Atoi(key)
+				if nil != err || 0 > index || len(current) <= index {
+					return "", false
+				}
+				element = current[index]
+			default:
+				return "", false
+			}
+		}
+	}
+	if text, ok := element.(string); ok {
+		return text, true
+	}
+	ret, err := json.Marshal(element)
+	if nil != err {
+		return "", false
+	}
+	return string(ret), true
+}
+
+// compiled regular expressions by pattern so a pattern is only
+// compiled once and not for every entity it is matched against.
+// invalid patterns are stored as nil and never match
+var patternCache = make(map[string]*regexp.Regexp)
+var patternMutex = &sync.RWMutex{}
+
+// maximum amount of cached patterns, the cache is
+// emptied if it grows beyond
+const patternCacheSize = 1024
+
+// returns the compiled regular expression for the operator
+// and beta, nil if it can't be compiled
+func getPattern(operator string, beta string) *regexp.Regexp {
+	pattern := getPatternString(operator, beta)
+	patternMutex.RLock()
+	r, ok := patternCache[pattern]
+	patternMutex.RUnlock()
+	if ok {
+		return r
+	}
+	r, err := regexp.Compile(pattern)
+	if nil != err {
+		r = nil
+	}
+	patternMutex.Lock()
+	if patternCacheSize <= len(patternCache) {
+		patternCache = make(map[string]*regexp.Regexp)
+	}
+	patternCache[pattern] = r
+	patternMutex.Unlock()
+	return r
+}
+
+func getPatternString(operator string, beta string) string {
+	if "iregex" == operator {
+		return "(?i)" + beta
+	}
+	return beta
+}
+
+// compares two numbers, integers are compared as they are and
+// everything else as float. returns false if any of both is no number
+func compareNumbers(alpha string, beta string) (int, bool) {
+	alphaInt, alphaErr := strconv.Atoi(alpha)
+	betaInt, betaErr := strconv.Atoi(beta)
+	if nil == alphaErr && nil == betaErr {
+		return compareInts(alphaInt, betaInt), true
+	}
+	alphaFloat, err := strconv.ParseFloat(alpha, 64)
+	if nil != err {
+		return 0, false
+	}
+	betaFloat, err := strconv.ParseFloat(beta, 64)
+	if nil != err {
+		return 0, false
+	}
+	if alphaFloat < betaFloat {
+		return -1, true
+	}
+	if alphaFloat > betaFloat {
+		return 1, true
+	}
+	return 0, true
+}
+
+// compares two RFC3339 timestamps. returns false if any of
+// both can't be parsed
+func compareTimes(alpha string, beta string) (int, bool) {
+	alphaTime, err := time.Parse(time.RFC3339, alpha)
+	if nil != err {
+		return 0, false
+	}
+	betaTime, err := time.Parse(time.RFC3339, beta)
+	if nil != err {
+		return 0, false
+	}
+	if alphaTime.Before(betaTime) {
+		return -1, true
+	}
+	if alphaTime.After(betaTime) {
+		return 1, true
+	}
+	return 0, true
+}
+
+// checks if alpha is within the bounds "min,max" given as beta
+// including the bounds. bounds are compared as numbers if both
+// are numbers and as RFC3339 timestamps else
+func matchBetween(alpha string, beta string) bool {
+	bounds := strings.SplitN(beta, ",", 2)
+	if 2 != len(bounds) {
+		return false
+	}
+	compare := compareTimes
+	if _, ok := compareNumbers(bounds[0], bounds[1]); ok {
+		compare = compareNumbers
+	}
+	lower, ok := compare(alpha, bounds[0])
+	if !ok || 0 > lower {
+		return false
+	}
+	upper, ok := compare(alpha, bounds[1])
+	return ok && 0 >= upper
+}
+
+func compareInts(alpha int, beta int) int {
+	if alpha < beta {
+		return -1
+	}
+	if alpha > beta {
+		return 1
+	}
+	return 0
+}
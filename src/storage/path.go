@@ -0,0 +1,441 @@
+package storage
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/voodooEntity/gits/src/transport"
+)
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// directions of path searches. the values of PATH_CHILD and PATH_BOTH
+// match DIRECTION_CHILD and DIRECTION_BOTH. the zero value is invalid,
+// so options without a direction fail instead of silently searching
+// towards the parents like DIRECTION_PARENT would
+type PathDirection int
+
+const (
+	// follows relations from source to target
+	PATH_CHILD PathDirection = DIRECTION_CHILD
+	// follows relations regardless of their direction
+	PATH_BOTH PathDirection = DIRECTION_BOTH
+	// follows relations from target to source
+	PATH_PARENT PathDirection = DIRECTION_BOTH + 1
+)
+
+// options to control path searches
+type PathOptions struct {
+	// PATH_CHILD, PATH_PARENT or PATH_BOTH, has to be set
+	Direction PathDirection
+	// entity types allowed on the path, start and end entity
+	// are always allowed. empty means all types are allowed
+	Types []string
+	// maximum amount of relations on a path, 0 means unlimited
+	MaxDepth int
+	// name of a relation property holding the weight of a
+	// relation. if set the cheapest instead of the shortest
+	// path is searched. relations without a valid weight count 1.
+	// only used by ShortestPath
+	WeightProperty string
+	// maximum amount of paths returned by FindPaths, 0 means unlimited
+	MaxPaths int
+	// maximum amount of path steps FindPaths is allowed to hold
+	// in memory while searching, 0 means unlimited
+	MaxExpansions int
+}
+
+// a single step from one entity to a related one, relation
+// holds the address [sType, sID, tType, tID] of the used relation
+type pathStep struct {
+	address  [2]int
+	relation [4]int
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// searches the shortest path between two entities. the result holds
+// the entities of the path in order from start to end and the
+// relations between them in the same order. if a WeightProperty is
+// given the path with the lowest weight sum is returned
+func (s *Storage) ShortestPath(from [2]int, to [2]int, opts PathOptions) (transport.Transport, error) {
+	s.EntityTypeMutex.RLock()
+	s.EntityStorageMutex.RLock()
+	s.RelationStorageMutex.RLock()
+	ret, err := s.ShortestPathUnsafe(from, to, opts)
+	s.RelationStorageMutex.RUnlock()
+	s.EntityStorageMutex.RUnlock()
+	s.EntityTypeMutex.RUnlock()
+	return ret, err
+}
+
+func (s *Storage) ShortestPathUnsafe(from [2]int, to [2]int, opts PathOptions) (transport.Transport, error) {
+	if err := opts.Validate(); nil != err {
+		return transport.Transport{}, err
+	}
+	if !s.EntityExistsUnsafe(from[0], from[1]) || !s.EntityExistsUnsafe(to[0], to[1]) {
+		return transport.Transport{}, errors.New("Start or end entity does not exist")
+	}
+
+	var steps []pathStep
+	var err error
+	if "" != opts.WeightProperty {
+		steps, err = s.weightedPathUnsafe(from, to, opts)
+	} else {
+		steps, err = s.bidirectionalPathUnsafe(from, to, opts)
+	}
+	if nil != err {
+		return transport.Transport{}, err
+	}
+
+	entities, relations := s.buildTransportPathUnsafe(from, steps)
+	return transport.Transport{
+		Entities:  entities,
+		Relations: relations,
+		Amount:    len(entities),
+	}, nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// enumerates all simple paths (no entity is visited twice) leading
+// from any of the from addresses to any of the to addresses. paths
+// leading over other targets are enumerated too. they are returned
+// ordered by their length, so using MaxPaths returns the k shortest paths.
+// if MaxExpansions is exceeded the search is aborted and the paths
+// found so far are returned together with an error
+func (s *Storage) FindPaths(from [][2]int, to [][2]int, opts PathOptions) (transport.Transport, error) {
+	s.EntityTypeMutex.RLock()
+	s.EntityStorageMutex.RLock()
+	s.RelationStorageMutex.RLock()
+	ret, err := s.FindPathsUnsafe(from, to, opts)
+	s.RelationStorageMutex.RUnlock()
+	s.EntityStorageMutex.RUnlock()
+	s.EntityTypeMutex.RUnlock()
+	return ret, err
+}
+
+func (s *Storage) FindPathsUnsafe(from [][2]int, to [][2]int, opts PathOptions) (transport.Transport, error) {
+	if err := opts.Validate(); nil != err {
+		return transport.Transport{}, err
+	}
+	allowedTypes := s.getPathTypesUnsafe(opts.Types)
+	targets := make(map[[2]int]bool)
+	for _, address := range to {
+		targets[address] = true
+	}
+
+	// partial paths are stored as linked nodes so
+	// paths with the same beginning share memory
+	type pathNode struct {
+		step   pathStep
+		parent *pathNode
+		depth  int
+	}
+	onPath := func(node *pathNode, address [2]int) bool {
+		for ; nil != node; node = node.parent {
+			if node.step.address == address {
+				return true
+			}
+		}
+		return false
+	}
+
+	var queue []*pathNode
+	for _, address := range from {
+		if s.EntityExistsUnsafe(address[0], address[1]) {
+			queue = append(queue, &pathNode{step: pathStep{address: address}})
+		}
+	}
+	expansions := len(queue)
+
+	ret := transport.Transport{}
+	for 0 < len(queue) {
+		node := queue[0]
+		queue = queue[1:]
+		if 0 < opts.MaxDepth && node.depth >= opts.MaxDepth {
+			continue
+		}
+		for _, step := range s.getPathStepsUnsafe(node.step.address, opts.Direction.getDirection()) {
+			if onPath(node, step.address) {
+				continue
+			}
+			next := &pathNode{step: step, parent: node, depth: node.depth + 1}
+			if targets[step.address] {
+				var steps []pathStep
+				current := next
+				for ; nil != current.parent; current = current.parent {
+					steps = append([]pathStep{current.step}, steps...)
+				}
+				entities, relations := s.buildTransportPathUnsafe(current.step.address, steps)
+				ret.Paths = append(ret.Paths, transport.TransportPath{Entities: entities, Relations: relations})
+				ret.Amount = len(ret.Paths)
+				if 0 < opts.MaxPaths && ret.Amount >= opts.MaxPaths {
+					return ret, nil
+				}
+			}
+			if nil != allowedTypes && !allowedTypes[step.address[0]] {
+				continue
+			}
+			expansions++
+			if 0 < opts.MaxExpansions && expansions > opts.MaxExpansions {
+				return ret, errors.New("Path search aborted, MaxExpansions exceeded")
+			}
+			queue = append(queue, next)
+		}
+	}
+	return ret, nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// checks if the options can be used for a path search
+func (opts PathOptions) Validate() error {
+	if PATH_CHILD != opts.Direction && PATH_PARENT != opts.Direction && PATH_BOTH != opts.Direction {
+		return errors.New("Invalid path direction, use PATH_CHILD, PATH_PARENT or PATH_BOTH")
+	}
+	return nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// + + + + + + + + + +  PRIVATE  + + + + + + + + + + +
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// breadth first search from both ends at once, always expanding
+// the smaller frontier. returns the steps leading from -> to
+func (s *Storage) bidirectionalPathUnsafe(from [2]int, to [2]int, opts PathOptions) ([]pathStep, error) {
+	if from == to {
+		return []pathStep{}, nil
+	}
+	allowedTypes := s.getPathTypesUnsafe(opts.Types)
+	forwardDirection := opts.Direction.getDirection()
+	reverseDirection := forwardDirection
+	if DIRECTION_CHILD == forwardDirection {
+		reverseDirection = DIRECTION_PARENT
+	} else if DIRECTION_PARENT == forwardDirection {
+		reverseDirection = DIRECTION_CHILD
+	}
+
+	// both maps store the step we used to reach an address
+	forwardSeen := map[[2]int]pathStep{from: {}}
+	backwardSeen := map[[2]int]pathStep{to: {}}
+	forwardFrontier := [][2]int{from}
+	backwardFrontier := [][2]int{to}
+	depth := 0
+
+	for 0 < len(forwardFrontier) && 0 < len(backwardFrontier) {
+		if 0 < opts.MaxDepth && depth >= opts.MaxDepth {
+			break
+		}
+		depth++
+
+		forward := len(forwardFrontier) <= len(backwardFrontier)
+		frontier, seen, other, direction := backwardFrontier, backwardSeen, forwardSeen, reverseDirection
+		if forward {
+			frontier, seen, other, direction = forwardFrontier, forwardSeen, backwardSeen, forwardDirection
+		}
+
+		var next [][2]int
+		for _, address := range frontier {
+			for _, step := range s.getPathStepsUnsafe(address, direction) {
+				if _, ok := seen[step.address]; ok {
+					continue
+				}
+				if step.address != from && step.address != to && nil != allowedTypes && !allowedTypes[step.address[0]] {
+					continue
+				}
+				seen[step.address] = pathStep{address: address, relation: step.relation}
+				if _, ok := other[step.address]; ok {
+					return joinPathSteps(step.address, forwardSeen, backwardSeen, from, to), nil
+				}
+				next = append(next, step.address)
+			}
+		}
+
+		if forward {
+			forwardFrontier = next
+		} else {
+			backwardFrontier = next
+		}
+	}
+	return nil, errors.New("No path found")
+}
+
+// combines the steps of both search directions meeting at the given address
+func joinPathSteps(meeting [2]int, forwardSeen map[[2]int]pathStep, backwardSeen map[[2]int]pathStep, from [2]int, to [2]int) []pathStep {
+	var steps []pathStep
+	for current := meeting; current != from; {
+		previous := forwardSeen[current]
+		steps = append([]pathStep{{address: current, relation: previous.relation}}, steps...)
+		current = previous.address
+	}
+	for current := meeting; current != to; {
+		next := backwardSeen[current]
+		steps = append(steps, pathStep{address: next.address, relation: next.relation})
+		current = next.address
+	}
+	return steps
+}
+
+// dijkstra search using a relation property as weight
+func (s *Storage) weightedPathUnsafe(from [2]int, to [2]int, opts PathOptions) ([]pathStep, error) {
+	allowedTypes := s.getPathTypesUnsafe(opts.Types)
+
+	// a state is an address plus the amount of hops used to reach it,
+	// hops are only tracked if a max depth is given
+	type state [3]int
+	start := state{from[0], from[1], 0}
+	cost := map[state]float64{start: 0}
+	previous := make(map[state]state)
+	usedStep := make(map[state]pathStep)
+	done := make(map[state]bool)
+	queue := &pathQueue{{state: start, cost: 0}}
+
+	for 0 < queue.Len() {
+		item := heap.Pop(queue).(pathQueueItem)
+		current := state(item.state)
+		if done[current] {
+			continue
+		}
+		done[current] = true
+
+		if current[0] == to[0] && current[1] == to[1] {
+			var steps []pathStep
+			for current != start {
+				steps = append([]pathStep{usedStep[current]}, steps...)
+				current = previous[current]
+			}
+			return steps, nil
+		}
+
+		hops := current[2] + 1
+		if 0 < opts.MaxDepth && hops > opts.MaxDepth {
+			continue
+		}
+		if 0 == opts.MaxDepth {
+			hops = 0
+		}
+
+		for _, step := range s.getPathStepsUnsafe([2]int{current[0], current[1]}, opts.Direction.getDirection()) {
+			if step.address != to && nil != allowedTypes && !allowedTypes[step.address[0]] {
+				continue
+			}
+			weight, err := s.getRelationWeightUnsafe(step.relation, opts.WeightProperty)
+			if nil != err {
+				return nil, err
+			}
+			nextState := state{step.address[0], step.address[1], hops}
+			if known, ok := cost[nextState]; ok && known <= item.cost+weight {
+				continue
+			}
+			cost[nextState] = item.cost + weight
+			previous[nextState] = current
+			usedStep[nextState] = step
+			heap.Push(queue, pathQueueItem{state: nextState, cost: item.cost + weight})
+		}
+	}
+	return nil, errors.New("No path found")
+}
+
+func (s *Storage) getRelationWeightUnsafe(relation [4]int, property string) (float64, error) {
+	value, ok := s.RelationStorage[relation[0]][relation[1]][relation[2]][relation[3]].Properties[property]
+	if !ok {
+		return 1, nil
+	}
+	weight, err := strconv.ParseFloat(value, 64)
+	if nil != err {
+		return 1, nil
+	}
+	if 0 > weight {
+		return 0, errors.New("Negative relation weights are not supported")
+	}
+	if math.IsNaN(weight) || math.IsInf(weight, 0) {
+		return 0, errors.New("NaN and infinite relation weights are not supported")
+	}
+	return weight, nil
+}
+
+// returns the DIRECTION_ constant used to look up related entities
+func (direction PathDirection) getDirection() int {
+	if PATH_PARENT == direction {
+		return DIRECTION_PARENT
+	}
+	return int(direction)
+}
+
+// returns all steps possible from the given address in the given direction
+func (s *Storage) getPathStepsUnsafe(address [2]int, direction int) []pathStep {
+	var steps []pathStep
+	if DIRECTION_CHILD == direction || DIRECTION_BOTH == direction {
+		for _, child := range s.getChildAddressesUnsafe(address, nil) {
+			steps = append(steps, pathStep{address: child, relation: [4]int{address[0], address[1], child[0], child[1]}})
+		}
+	}
+	if DIRECTION_PARENT == direction || DIRECTION_BOTH == direction {
+		for _, parent := range s.getParentAddressesUnsafe(address, nil) {
+			steps = append(steps, pathStep{address: parent, relation: [4]int{parent[0], parent[1], address[0], address[1]}})
+		}
+	}
+	return steps
+}
+
+// returns nil if all types are allowed
+func (s *Storage) getPathTypesUnsafe(typeList []string) map[int]bool {
+	if 0 == len(typeList) {
+		return nil
+	}
+	return s.getTypeFilterUnsafe(typeList)
+}
+
+// builds the ordered entities and relations for a start address and the steps taken from it
+func (s *Storage) buildTransportPathUnsafe(from [2]int, steps []pathStep) ([]transport.TransportEntity, []transport.TransportRelation) {
+	entities := []transport.TransportEntity{s.entityToTransportUnsafe(from)}
+	relations := []transport.TransportRelation{}
+	for _, step := range steps {
+		entities = append(entities, s.entityToTransportUnsafe(step.address))
+		relations = append(relations, s.relationToTransportUnsafe(step.relation))
+	}
+	return entities, relations
+}
+
+func (s *Storage) entityToTransportUnsafe(address [2]int) transport.TransportEntity {
+	entity := s.deepCopyEntity(s.EntityStorage[address[0]][address[1]])
+	return transport.TransportEntity{
+		Type:       s.EntityTypes[entity.Type],
+		ID:         entity.ID,
+		Value:      entity.Value,
+		Context:    entity.Context,
+		Version:    entity.Version,
+		Properties: entity.Properties,
+	}
+}
+
+func (s *Storage) relationToTransportUnsafe(address [4]int) transport.TransportRelation {
+	relation := s.deepCopyRelation(s.RelationStorage[address[0]][address[1]][address[2]][address[3]])
+	return transport.TransportRelation{
+		Context:    relation.Context,
+		Properties: relation.Properties,
+		SourceType: s.EntityTypes[address[0]],
+		SourceID:   address[1],
+		TargetType: s.EntityTypes[address[2]],
+		TargetID:   address[3],
+		Version:    relation.Version,
+	}
+}
+
+// priority queue used by the weighted path search
+type pathQueueItem struct {
+	state [3]int
+	cost  float64
+}
+
+type pathQueue []pathQueueItem
+
+func (q pathQueue) Len() int            { return len(q) }
+func (q pathQueue) Less(i, j int) bool  { return q[i].cost < q[j].cost }
+func (q pathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q, x.(pathQueueItem)) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/voodooEntity/gits/src/transport"
 	"github.com/voodooEntity/gits/src/types"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -25,6 +26,11 @@ type Storage struct {
 	RelationStorage      map[int]map[int]map[int]map[int]types.StorageRelation
 	RelationRStorage     map[int]map[int]map[int]map[int]bool
 	RelationStorageMutex *sync.RWMutex
+	DagMode              bool
+	DagTypes             map[int]bool
+	HistoryTypes         map[int]HistoryConfig
+	EntityHistory        map[[2]int][]EntityVersion
+	RelationHistory      map[[4]int][]RelationVersion
 }
 
 const (
@@ -37,6 +43,7 @@ const (
 	DIRECTION_NONE   = -1
 	DIRECTION_PARENT = 0
 	DIRECTION_CHILD  = 1
+	DIRECTION_BOTH   = 2
 )
 
 func NewStorage() *Storage {
@@ -76,6 +83,18 @@ func NewStorage() *Storage {
 
 		// relation storage master mutex
 		RelationStorageMutex: &sync.RWMutex{},
+
+		// - - - - - - - - - - - - - - - - - - - - - - - - - -
+		// dag enforcement, either for the whole storage or
+		// per entity type. both are guarded by the EntityTypeMutex
+		DagMode:  false,
+		DagTypes: make(map[int]bool),
+
+		// - - - - - - - - - - - - - - - - - - - - - - - - - -
+		// version history, only filled for types with history enabled
+		HistoryTypes:    make(map[int]HistoryConfig),
+		EntityHistory:   make(map[[2]int][]EntityVersion),
+		RelationHistory: make(map[[4]int][]RelationVersion),
 	}
 }
 
@@ -249,6 +268,7 @@ func (s *Storage) CreateEntity(entity types.StorageEntity) (int, error) {
 	// now we store the entity element
 	// in the EntityStorage
 	s.EntityStorage[entity.Type][newID] = entity
+	s.recordEntityUnsafe(entity)
 
 	//printMutexActions("CreateEntity.EntityStorageMutex.Unlock");
 	s.EntityStorageMutex.Unlock()
@@ -318,6 +338,7 @@ func (s *Storage) CreateEntityUnsafe(entity types.StorageEntity) (int, error) {
 	// now we store the entity element
 	// in the EntityStorage
 	s.EntityStorage[entity.Type][newID] = entity
+	s.recordEntityUnsafe(entity)
 
 	// create the mutex for our ressource on
 	// relation. we have to create the sub maps too
@@ -409,6 +430,7 @@ func (s *Storage) CreateEntityUniqueValue(entity types.StorageEntity) (int, bool
 	// now we store the entity element
 	// in the EntityStorage
 	s.EntityStorage[entity.Type][newID] = entity
+	s.recordEntityUnsafe(entity)
 
 	//printMutexActions("CreateEntity.EntityStorageMutex.Unlock");
 	s.EntityStorageMutex.Unlock()
@@ -499,6 +521,7 @@ func (s *Storage) CreateEntityUniqueValueUnsafe(entity types.StorageEntity) (int
 	// now we store the entity element
 	// in the EntityStorage
 	s.EntityStorage[entity.Type][newID] = entity
+	s.recordEntityUnsafe(entity)
 
 	//printMutexActions("CreateEntity.EntityStorageMutex.Unlock");
 
@@ -939,6 +962,9 @@ func (s *Storage) UpdateEntity(entity types.StorageEntity) error {
 			return errors.New("Mismatch of version.")
 		}
 		entity.Version++
+		if nil == entity.Properties {
+			entity.Properties = make(map[string]string)
+		}
 
 		// - - - - - - - - - - - - - - - - -
 		// persistence.go handling
@@ -951,6 +977,7 @@ func (s *Storage) UpdateEntity(entity types.StorageEntity) error {
 		}
 		// - - - - - - - - - - - - - - - - -
 		s.EntityStorage[entity.Type][entity.ID] = entity
+		s.recordEntityUnsafe(entity)
 		s.EntityStorageMutex.Unlock()
 		return nil
 	}
@@ -970,6 +997,9 @@ func (s *Storage) UpdateEntityUnsafe(entity types.StorageEntity) error {
 			return errors.New("Mismatch of version.")
 		}
 		entity.Version++
+		if nil == entity.Properties {
+			entity.Properties = make(map[string]string)
+		}
 
 		// - - - - - - - - - - - - - - - - -
 		// persistence.go handling
@@ -982,6 +1012,7 @@ func (s *Storage) UpdateEntityUnsafe(entity types.StorageEntity) error {
 		}
 		// - - - - - - - - - - - - - - - - -
 		s.EntityStorage[entity.Type][entity.ID] = entity
+		s.recordEntityUnsafe(entity)
 		return nil
 	}
 
@@ -1007,6 +1038,7 @@ func (s *Storage) DeleteEntity(Type int, id int) {
 	}
 	// - - - - - - - - - - - - - - - - -
 	delete(s.EntityStorage[Type], id)
+	s.recordEntityDeleteUnsafe(Type, id)
 	s.EntityStorageMutex.Unlock()
 	// now we delete the relations from and to this entity
 	// first child
@@ -1032,6 +1064,7 @@ func (s *Storage) DeleteEntityUnsafe(Type int, id int) {
 	}
 	// - - - - - - - - - - - - - - - - -
 	delete(s.EntityStorage[Type], id)
+	s.recordEntityDeleteUnsafe(Type, id)
 	// now we delete the relations from and to this entity
 	// first child
 	s.DeleteChildRelationsUnsafe(Type, id)
@@ -1143,6 +1176,7 @@ func (s *Storage) DeleteRelation(sourceType int, sourceID int, targetType int, t
 	// - - - - - - - - - - - - - - - - -
 	delete(s.RelationStorage[sourceType][sourceID][targetType], targetID)
 	delete(s.RelationRStorage[targetType][targetID][sourceType], sourceID)
+	s.recordRelationDeleteUnsafe(sourceType, sourceID, targetType, targetID)
 	s.RelationStorageMutex.Unlock()
 }
 
@@ -1164,6 +1198,7 @@ func (s *Storage) DeleteRelationUnsafe(sourceType int, sourceID int, targetType
 	// - - - - - - - - - - - - - - - - -
 	delete(s.RelationStorage[sourceType][sourceID][targetType], targetID)
 	delete(s.RelationRStorage[targetType][targetID][sourceType], sourceID)
+	s.recordRelationDeleteUnsafe(sourceType, sourceID, targetType, targetID)
 }
 
 func (s *Storage) DeleteChildRelations(Type int, id int) error {
@@ -1218,12 +1253,19 @@ func (s *Storage) CreateRelation(srcType int, srcID int, targetType int, targetI
 		s.EntityTypeMutex.RUnlock()
 		return false, errors.New("Target Type not existing")
 	}
-	// finally unlock the TypeMutex again if both checks were successfull
-	s.EntityTypeMutex.RUnlock()
 	//// - - - - - - - - - - - - - - - - -
 	// now we lock the relation mutex
 	//printMutexActions("CreateRelation.RelationStorageMutex.Lock");
 	s.RelationStorageMutex.Lock()
+	// if dag mode applies we make sure the new relation
+	// doesnt close a cycle before we store anything
+	if s.relationClosesCycleUnsafe(srcType, srcID, targetType, targetID) {
+		s.RelationStorageMutex.Unlock()
+		s.EntityTypeMutex.RUnlock()
+		return false, errors.New("Relation would close a cycle")
+	}
+	// finally unlock the TypeMutex again if all checks were successfull
+	s.EntityTypeMutex.RUnlock()
 	// lets check if their exists a map for our
 	// source entity to the target Type if not
 	// create it.... golang things...
@@ -1245,6 +1287,7 @@ func (s *Storage) CreateRelation(srcType int, srcID int, targetType int, targetI
 	relation.Version = 1
 	// now we store the relation
 	s.RelationStorage[srcType][srcID][targetType][targetID] = relation
+	s.recordRelationUnsafe(srcType, srcID, targetType, targetID, relation)
 	// - - - - - - - - - - - - - - - - -
 	// persistence.go handling
 	if true == persistenceFlag {
@@ -1281,6 +1324,11 @@ func (s *Storage) CreateRelationUnsafe(srcType int, srcID int, targetType int, t
 		//printMutexActions("CreateRelation.EntityTypeMutex.RUnlock");
 		return false, errors.New("Target Type not existing")
 	}
+	// if dag mode applies we make sure the new relation
+	// doesnt close a cycle before we store anything
+	if s.relationClosesCycleUnsafe(srcType, srcID, targetType, targetID) {
+		return false, errors.New("Relation would close a cycle")
+	}
 	// finally unlock the TypeMutex again if both checks were successfull
 	//// - - - - - - - - - - - - - - - - -
 	// now we lock the relation mutex
@@ -1306,6 +1354,7 @@ func (s *Storage) CreateRelationUnsafe(srcType int, srcID int, targetType int, t
 	relation.Version = 1
 	// now we store the relation
 	s.RelationStorage[srcType][srcID][targetType][targetID] = relation
+	s.recordRelationUnsafe(srcType, srcID, targetType, targetID, relation)
 	// - - - - - - - - - - - - - - - - -
 	// persistence.go handling
 	if true == persistenceFlag {
@@ -1356,7 +1405,11 @@ func (s *Storage) UpdateRelation(srcType int, srcID int, targetType int, targetI
 					// update the data itself
 					rel.Context = relation.Context
 					rel.Properties = relation.Properties
+					if nil == rel.Properties {
+						rel.Properties = make(map[string]string)
+					}
 					s.RelationStorage[srcType][srcID][targetType][targetID] = rel
+					s.recordRelationUnsafe(srcType, srcID, targetType, targetID, rel)
 					s.RelationStorageMutex.Unlock()
 					return relation, nil
 				}
@@ -1393,7 +1446,11 @@ func (s *Storage) UpdateRelationUnsafe(srcType int, srcID int, targetType int, t
 					// update the data itself
 					rel.Context = relation.Context
 					rel.Properties = relation.Properties
+					if nil == rel.Properties {
+						rel.Properties = make(map[string]string)
+					}
 					s.RelationStorage[srcType][srcID][targetType][targetID] = rel
+					s.recordRelationUnsafe(srcType, srcID, targetType, targetID, rel)
 					return relation, nil
 				}
 			}
@@ -1936,6 +1993,28 @@ func (s *Storage) GetEntitiesByQueryFilter(
 	contextFilter [][]int,
 	propertyList []map[string][]int,
 	returnDataFlag bool,
+	selection ...string,
+) (
+	[]transport.TransportEntity,
+	[][2]int,
+	int,
+) {
+	return s.GetEntitiesByQueryFilterTree(typePool, conditions, idFilter, valueFilter, contextFilter, propertyList, nil, returnDataFlag, selection...)
+}
+
+// works like GetEntitiesByQueryFilter but if a condition tree is
+// given the entities have to match the tree instead of any of
+// the condition groups
+func (s *Storage) GetEntitiesByQueryFilterTree(
+	typePool []string,
+	conditions [][][3]string,
+	idFilter [][]int,
+	valueFilter [][]int,
+	contextFilter [][]int,
+	propertyList []map[string][]int,
+	tree *ConditionNode,
+	returnDataFlag bool,
+	selection ...string,
 ) (
 	[]transport.TransportEntity,
 	[][2]int,
@@ -1964,71 +2043,15 @@ func (s *Storage) GetEntitiesByQueryFilter(
 	for _, typeID := range typeList {
 		// lets walk through this pools entities
 		for entityID, entity := range s.EntityStorage[typeID] {
-			add := false
-			// if there are matchgroups
-			if 0 < len(conditions) {
-				for conditionGroupKey, conditionGroup := range conditions {
-					// first we check if there is an ID filter
-					// ### could have a special case for == on
-					// id since this can be resolved very fast
-					if 0 < len(idFilter[conditionGroupKey]) && !s.matchGroup(idFilter[conditionGroupKey], conditionGroup, strconv.Itoa(entityID)) {
-						continue
-					}
-					// now we value
-					if 0 < len(valueFilter[conditionGroupKey]) && !s.matchGroup(valueFilter[conditionGroupKey], conditionGroup, entity.Value) {
-						continue
-					}
-					// than context
-					if 0 < len(contextFilter[conditionGroupKey]) && !s.matchGroup(contextFilter[conditionGroupKey], conditionGroup, entity.Context) {
-						continue
-					}
-					// and now the properties
-					contGroupLoop := false
-					for propertyKey, propertyConditions := range propertyList[conditionGroupKey] {
-						if _, ok := entity.Properties[propertyKey]; ok {
-							if !s.matchGroup(propertyConditions, conditionGroup, entity.Properties[propertyKey]) {
-								contGroupLoop = true // ### refactor this i dont like it a bit but dont see a better way right now
-								break
-							}
-						} else {
-							// property does not exist
-							contGroupLoop = true
-							break
-						}
-					}
-					// ### we broke out of the inner loop means we have to continue the condition loop
-					if contGroupLoop {
-						continue
-					}
-					// if we are still in here all the applied filters worked
-					add = true
-					// if we got here we can break out since the entity has been added
-					break
-				}
-			} else {
-				// we got no conditions so basicly just hit on every of this type
-				add = true
-			}
 			// do we need to add this dataset?
-			if true == add {
+			if s.matchQueryFilter(entity, entityID, conditions, idFilter, valueFilter, contextFilter, propertyList, tree) {
 				// and we can add the entity to our resultList
 				if returnDataFlag {
-					// first we copy the properties
-					props := make(map[string]string)
-					for key, value := range entity.Properties {
-						props[key] = value
-					}
-					// than we add the ResultEntity itself
-					resultEntities = append(resultEntities, transport.TransportEntity{
-						Type:            s.EntityTypes[entity.Type],
-						ID:              entity.ID,
-						Value:           entity.Value,
-						Context:         entity.Context,
-						Version:         entity.Version,
-						Properties:      props,
-						ParentRelations: []transport.TransportRelation{},
-						ChildRelations:  []transport.TransportRelation{},
-					})
+					// copy the selected fields of the entity
+					resultEntity := s.selectEntityFieldsUnsafe(entity, selection)
+					resultEntity.ParentRelations = []transport.TransportRelation{}
+					resultEntity.ChildRelations = []transport.TransportRelation{}
+					resultEntities = append(resultEntities, resultEntity)
 				}
 				resultAddresses = append(resultAddresses, [2]int{entity.Type, entityID})
 			}
@@ -2047,6 +2070,29 @@ func (s *Storage) GetEntitiesByQueryFilterAndSourceAddress(
 	sourceAddress [2]int,
 	direction int,
 	returnDataFlag bool,
+	selection ...string,
+) (
+	[]transport.TransportRelation,
+	[][2]int,
+	int,
+) {
+	return s.GetEntitiesByQueryFilterAndSourceAddressTree(typePool, conditions, idFilter, valueFilter, contextFilter, propertyList, nil, sourceAddress, direction, returnDataFlag, selection...)
+}
+
+// works like GetEntitiesByQueryFilterAndSourceAddress with the
+// condition tree handled like in GetEntitiesByQueryFilterTree
+func (s *Storage) GetEntitiesByQueryFilterAndSourceAddressTree(
+	typePool []string,
+	conditions [][][3]string,
+	idFilter [][]int,
+	valueFilter [][]int,
+	contextFilter [][]int,
+	propertyList []map[string][]int,
+	tree *ConditionNode,
+	sourceAddress [2]int,
+	direction int,
+	returnDataFlag bool,
+	selection ...string,
 ) (
 	[]transport.TransportRelation,
 	[][2]int,
@@ -2085,75 +2131,19 @@ func (s *Storage) GetEntitiesByQueryFilterAndSourceAddress(
 	// now we know which IDs we have to check, so lets iterate through them
 	for targetType, targetIDlist := range relPool {
 		for _, targetID := range targetIDlist {
-			add := false
 			entity := s.EntityStorage[targetType][targetID]
-			if 0 < len(conditions) {
-				for conditionGroupKey, conditionGroup := range conditions {
-					// first we check if there is an ID filter
-					// ### could have a special case for == on
-					// id since this can be resolved very fast
-					if !s.matchGroup(idFilter[conditionGroupKey], conditionGroup, strconv.Itoa(targetID)) {
-						continue
-					}
-					// now we value
-					if !s.matchGroup(valueFilter[conditionGroupKey], conditionGroup, entity.Value) {
-						continue
-					}
-					// than context
-					if !s.matchGroup(contextFilter[conditionGroupKey], conditionGroup, entity.Context) {
-						continue
-					}
-					// and now the properties
-					contGroupLoop := false
-					for propertyKey, propertyConditions := range propertyList[conditionGroupKey] {
-						if _, ok := entity.Properties[propertyKey]; ok {
-							if !s.matchGroup(propertyConditions, conditionGroup, entity.Properties[propertyKey]) {
-								contGroupLoop = true // ### refactor this i dont like it a bit but dont see a better way right now
-								break
-							}
-						} else {
-							// property does not exist
-							contGroupLoop = true
-							break
-						}
-					}
-					// ### we broke out of the inner loop means we have to continue the condition loop
-					if contGroupLoop {
-						continue
-					}
-					// if we are still in here all the applied filters worked
-					// and we can add the entity to our resultList
-					add = true
-					// if we got here we can break out since the entity has been added
-					break
-				}
-			} else {
-				// we have no conditions so we add it anyway
-				add = true
-			}
 
 			// if we add the data
-			if true == add {
+			if s.matchQueryFilter(entity, targetID, conditions, idFilter, valueFilter, contextFilter, propertyList, tree) {
 				if returnDataFlag {
-					// first we copy the properties
-					props := make(map[string]string)
-					for key, value := range entity.Properties {
-						props[key] = value
-					}
-					// than we add the ResultEntity itself
+					// copy the selected fields of the entity
+					target := s.selectEntityFieldsUnsafe(entity, selection)
+					target.ParentRelations = []transport.TransportRelation{}
+					target.ChildRelations = []transport.TransportRelation{}
 					resultEntities = append(resultEntities, transport.TransportRelation{
 						Context:    s.getRelationContextByAddressAndDirection(sourceAddress[0], sourceAddress[1], targetType, targetID, direction),
 						Properties: s.getRelationPropertiesByAddressAndDirection(sourceAddress[0], sourceAddress[1], targetType, targetID, direction),
-						Target: transport.TransportEntity{
-							Type:            s.EntityTypes[entity.Type],
-							ID:              entity.ID,
-							Value:           entity.Value,
-							Context:         entity.Context,
-							Version:         entity.Version,
-							Properties:      props,
-							ParentRelations: []transport.TransportRelation{},
-							ChildRelations:  []transport.TransportRelation{},
-						},
+						Target:     target,
 					})
 				}
 				resultAddresses = append(resultAddresses, [2]int{entity.Type, targetID})
@@ -2164,25 +2154,252 @@ func (s *Storage) GetEntitiesByQueryFilterAndSourceAddress(
 	return resultEntities, resultAddresses, len(resultAddresses)
 }
 
-func (s *Storage) BatchUpdateAddressList(addressList [][2]int, values map[string]string) {
-	for _, address := range addressList {
-		entity, _ := s.GetEntityByPathUnsafe(address[0], address[1], "")
-		for key, value := range values {
-			switch key {
-			case "Value":
-				entity.Value = value
-			case "Context":
-				entity.Context = value
-			default:
-				if -1 != strings.Index(key, "Properties") {
-					// ### we nmeed to prepare the map here if it doesnt exist
-					entity.Properties[key[11:]] = value
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// works like GetEntitiesByQueryFilterAndSourceAddress but matches all
+// entities reachable from the source address within minHops to maxHops
+// relations in the given direction. if viaTypes is not empty all entities
+// between the source and the matched entity have to be of one of the
+// given types. the returned relation data is the direct relation to
+// the source address if one exists, else it stays empty. the source
+// address itself is never part of the result
+func (s *Storage) GetEntitiesByQueryFilterAndSourceAddressHops(
+	typePool []string,
+	conditions [][][3]string,
+	idFilter [][]int,
+	valueFilter [][]int,
+	contextFilter [][]int,
+	propertyList []map[string][]int,
+	sourceAddress [2]int,
+	direction int,
+	minHops int,
+	maxHops int,
+	viaTypes []string,
+	returnDataFlag bool,
+	selection ...string,
+) (
+	[]transport.TransportRelation,
+	[][2]int,
+	int,
+) {
+	return s.GetEntitiesByQueryFilterAndSourceAddressHopsTree(typePool, conditions, idFilter, valueFilter, contextFilter, propertyList, nil, sourceAddress, direction, minHops, maxHops, viaTypes, returnDataFlag, selection...)
+}
+
+// works like GetEntitiesByQueryFilterAndSourceAddressHops with the
+// condition tree handled like in GetEntitiesByQueryFilterTree
+func (s *Storage) GetEntitiesByQueryFilterAndSourceAddressHopsTree(
+	typePool []string,
+	conditions [][][3]string,
+	idFilter [][]int,
+	valueFilter [][]int,
+	contextFilter [][]int,
+	propertyList []map[string][]int,
+	tree *ConditionNode,
+	sourceAddress [2]int,
+	direction int,
+	minHops int,
+	maxHops int,
+	viaTypes []string,
+	returnDataFlag bool,
+	selection ...string,
+) (
+	[]transport.TransportRelation,
+	[][2]int,
+	int,
+) {
+	// check the pools given
+	typeList := make(map[int]bool)
+	for _, eType := range typePool {
+		if val, ok := s.EntityRTypes[eType]; ok {
+			typeList[val] = true
+		}
+	}
+
+	// do we have any types in pool left?
+	if 0 == len(typeList) {
+		return nil, nil, 0
+	}
+
+	if 1 > minHops {
+		minHops = 1
+	}
+	if maxHops < minHops {
+		maxHops = minHops
+	}
+	stepDirection := DIRECTION_PARENT
+	if 1 == direction {
+		stepDirection = DIRECTION_CHILD
+	}
+	allowedVia := s.getPathTypesUnsafe(viaTypes)
+
+	// we walk level by level, every level holds the entities reachable
+	// with exactly that amount of hops. since the same entity can be
+	// reached with different amounts of hops we can not simply skip
+	// visited entities, but an identical level can't lead to anything new
+	reachable := make(map[[2]int]bool)
+	frontier := map[[2]int]bool{sourceAddress: true}
+	for hop := 1; hop <= maxHops && 0 < len(frontier); hop++ {
+		next := make(map[[2]int]bool)
+		for address := range frontier {
+			// everything besides the source is an intermediate entity now
+			if 1 < hop && nil != allowedVia && !allowedVia[address[0]] {
+				continue
+			}
+			for _, step := range s.getPathStepsUnsafe(address, stepDirection) {
+				next[step.address] = true
+			}
+		}
+		if hop >= minHops {
+			for address := range next {
+				if address != sourceAddress {
+					reachable[address] = true
 				}
 			}
 		}
-		// ### handle errors
-		s.UpdateEntityUnsafe(entity)
+		if len(next) == len(frontier) {
+			same := true
+			for address := range next {
+				if !frontier[address] {
+					same = false
+					break
+				}
+			}
+			if same && hop >= minHops {
+				break
+			}
+		}
+		frontier = next
+	}
+
+	// sort the candidates to keep results reproducible
+	var candidates [][2]int
+	for address := range reachable {
+		if typeList[address[0]] {
+			candidates = append(candidates, address)
+		}
+	}
+	sortAddresses(candidates)
+
+	// prepare results
+	var resultEntities []transport.TransportRelation
+	var resultAddresses [][2]int
+	for _, address := range candidates {
+		entity := s.EntityStorage[address[0]][address[1]]
+		if !s.matchQueryFilter(entity, address[1], conditions, idFilter, valueFilter, contextFilter, propertyList, tree) {
+			continue
+		}
+		if returnDataFlag {
+			relation := transport.TransportRelation{}
+			if s.hasDirectRelationUnsafe(sourceAddress, address, direction) {
+				relation = s.relationToTransportUnsafe(s.getDirectRelationAddress(sourceAddress, address, direction))
+			}
+			relation.Target = s.selectEntityFieldsUnsafe(entity, selection)
+			relation.Target.ParentRelations = []transport.TransportRelation{}
+			relation.Target.ChildRelations = []transport.TransportRelation{}
+			resultEntities = append(resultEntities, relation)
+		}
+		resultAddresses = append(resultAddresses, address)
+	}
+
+	return resultEntities, resultAddresses, len(resultAddresses)
+}
+
+// returns the address [sType, sID, tType, tID] of the relation
+// between source and target seen from the given direction
+func (s *Storage) getDirectRelationAddress(sourceAddress [2]int, targetAddress [2]int, direction int) [4]int {
+	if 1 == direction {
+		return [4]int{sourceAddress[0], sourceAddress[1], targetAddress[0], targetAddress[1]}
+	}
+	return [4]int{targetAddress[0], targetAddress[1], sourceAddress[0], sourceAddress[1]}
+}
+
+func (s *Storage) hasDirectRelationUnsafe(sourceAddress [2]int, targetAddress [2]int, direction int) bool {
+	relation := s.getDirectRelationAddress(sourceAddress, targetAddress, direction)
+	return s.RelationExistsUnsafe(relation[0], relation[1], relation[2], relation[3])
+}
+
+func (s *Storage) BatchUpdateAddressList(addressList [][2]int, values map[string]string) map[[2]int]error {
+	return s.BatchUpdateAddressListIfVersion(addressList, values, nil)
+}
+
+// updates all given addresses with the given values. entities
+// having an expected version in versions are only updated if their
+// current version matches. returns the error per address that
+// could not be updated
+func (s *Storage) BatchUpdateAddressListIfVersion(addressList [][2]int, values map[string]string, versions map[[2]int]int) map[[2]int]error {
+	return s.BatchModifyAddressList(addressList, values, nil, versions)
+}
+
+// returns the entities of the given addresses in transport format,
+// addresses of non existing entities are skipped. if fields are
+// selected only those are copied, see selectEntityFieldsUnsafe
+func (s *Storage) GetEntitiesByAddressListUnsafe(addressList [][2]int, selection ...string) []transport.TransportEntity {
+	var ret []transport.TransportEntity
+	for _, address := range addressList {
+		if entity, ok := s.EntityStorage[address[0]][address[1]]; ok {
+			ret = append(ret, s.selectEntityFieldsUnsafe(entity, selection))
+		}
 	}
+	return ret
+}
+
+// returns all relations from or to the entities of the given
+// addresses in transport format. every relation is returned once
+func (s *Storage) GetRelationsByAddressListUnsafe(addressList [][2]int) []transport.TransportRelation {
+	var ret []transport.TransportRelation
+	seen := make(map[[4]int]bool)
+	for _, address := range addressList {
+		for targetType, targets := range s.RelationStorage[address[0]][address[1]] {
+			for targetID := range targets {
+				seen[[4]int{address[0], address[1], targetType, targetID}] = true
+			}
+		}
+		for sourceType, sources := range s.RelationRStorage[address[0]][address[1]] {
+			for sourceID := range sources {
+				seen[[4]int{sourceType, sourceID, address[0], address[1]}] = true
+			}
+		}
+	}
+	addresses := make([][4]int, 0, len(seen))
+	for relationAddress := range seen {
+		addresses = append(addresses, relationAddress)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		for k := 0; k < 4; k++ {
+			if addresses[i][k] != addresses[j][k] {
+				return addresses[i][k] < addresses[j][k]
+			}
+		}
+		return false
+	})
+	for _, relationAddress := range addresses {
+		ret = append(ret, s.relationToTransportUnsafe(relationAddress))
+	}
+	return ret
+}
+
+// returns the value of a field ("ID", "Value", "Context", "Version"
+// or "Properties.name") of the entity on the given address
+// and if it exists
+func (s *Storage) GetEntityFieldByAddressUnsafe(address [2]int, field string) (string, bool) {
+	entity, ok := s.EntityStorage[address[0]][address[1]]
+	if !ok {
+		return "", false
+	}
+	return s.getEntityField(entity, field)
+}
+
+// returns the given relations in transport format. non existing
+// relations are skipped and every relation is returned once
+func (s *Storage) GetRelationsByRelationAddressListUnsafe(addressList [][4]int) []transport.TransportRelation {
+	var ret []transport.TransportRelation
+	seen := make(map[[4]int]bool)
+	for _, address := range addressList {
+		if _, ok := s.RelationStorage[address[0]][address[1]][address[2]][address[3]]; ok && !seen[address] {
+			seen[address] = true
+			ret = append(ret, s.relationToTransportUnsafe(address))
+		}
+	}
+	return ret
 }
 
 func (s *Storage) BatchDeleteAddressList(addressList [][2]int) {
@@ -2197,20 +2414,51 @@ func (s *Storage) LinkAddressLists(from [][2]int, to [][2]int) int {
 		for _, singleTo := range to {
 			// do we already have a relation between those too?`if not we create it
 			if !s.RelationExistsUnsafe(singleFrom[0], singleFrom[1], singleTo[0], singleTo[1]) {
-				s.CreateRelationUnsafe(singleFrom[0], singleFrom[1], singleTo[0], singleTo[1], types.StorageRelation{
+				created, _ := s.CreateRelationUnsafe(singleFrom[0], singleFrom[1], singleTo[0], singleTo[1], types.StorageRelation{
 					SourceType: singleFrom[0],
 					SourceID:   singleFrom[1],
 					TargetType: singleTo[0],
 					TargetID:   singleTo[1],
 				})
 				// archivist.Debug("Creating link from to ", singleFrom[0], singleFrom[1], singleTo[0], singleTo[1])
-				linkedAmount++
+				// relations rejected by dag mode are not counted
+				if created {
+					linkedAmount++
+				}
 			}
 		}
 	}
 	return linkedAmount
 }
 
+// returns the relations LinkAddressLists would create without
+// creating them. the cycle protection of the dag mode is checked
+// against the current relations only
+func (s *Storage) PreviewLinkAddressListsUnsafe(from [][2]int, to [][2]int) []transport.TransportRelation {
+	var ret []transport.TransportRelation
+	seen := make(map[[4]int]bool)
+	for _, singleFrom := range from {
+		for _, singleTo := range to {
+			address := [4]int{singleFrom[0], singleFrom[1], singleTo[0], singleTo[1]}
+			if seen[address] || s.RelationExistsUnsafe(address[0], address[1], address[2], address[3]) {
+				continue
+			}
+			if s.relationClosesCycleUnsafe(address[0], address[1], address[2], address[3]) {
+				continue
+			}
+			seen[address] = true
+			ret = append(ret, transport.TransportRelation{
+				SourceType: s.EntityTypes[address[0]],
+				SourceID:   address[1],
+				TargetType: s.EntityTypes[address[2]],
+				TargetID:   address[3],
+				Properties: make(map[string]string),
+			})
+		}
+	}
+	return ret
+}
+
 func (s *Storage) TraverseEnrich(entity *transport.TransportEntity, direction int, depth int) {
 	if 1 > depth {
 		// we reached max depth nuttin to do here
@@ -2324,15 +2572,51 @@ func (s *Storage) getRRelationTargetIDsBySourceAddressAndTargetType(sourceType i
 	return ret
 }
 
+// checks if an entity matches any of the given condition groups,
+// no conditions at all means the entity matches. if a condition
+// tree is given the entity has to match the tree instead
+func (s *Storage) matchQueryFilter(
+	entity types.StorageEntity,
+	entityID int,
+	conditions [][][3]string,
+	idFilter [][]int,
+	valueFilter [][]int,
+	contextFilter [][]int,
+	propertyList []map[string][]int,
+	tree *ConditionNode,
+) bool {
+	if nil != tree {
+		return s.matchConditionTree(tree, entity, entityID, conditions, idFilter, valueFilter, contextFilter, propertyList)
+	}
+	// we got no conditions so basicly just hit on every entity
+	if 0 == len(conditions) {
+		return true
+	}
+	for conditionGroupKey := range conditions {
+		if s.matchConditionGroup(conditionGroupKey, entity, entityID, conditions, idFilter, valueFilter, contextFilter, propertyList) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Storage) matchGroup(filterGroup []int, conditions [][3]string, test string) bool {
 	for _, filterGroupID := range filterGroup {
-		if !s.match(test, conditions[filterGroupID][1], conditions[filterGroupID][2]) {
+		if !s.matchCondition(test, conditions[filterGroupID]) {
 			return false
 		}
 	}
 	return true
 }
 
+// applies the transforms of the condition to the value
+// and checks if it matches the condition
+func (s *Storage) matchCondition(value string, condition [3]string) bool {
+	value, ok := applyTransforms(value, condition[0])
+	return ok && s.match(value, condition[1], condition[2])
+}
+
+// checks if alpha matches beta using the operator
 func (s *Storage) match(alpha string, operator string, beta string) bool {
 	switch operator {
 	case "==":
@@ -2358,54 +2642,45 @@ func (s *Storage) match(alpha string, operator string, beta string) bool {
 		if strings.Contains(alpha, beta) {
 			return true
 		}
-	case ">":
-		alphaInt, err := strconv.Atoi(alpha)
-		if nil != err {
-			return false
-		}
-		betaInt, err := strconv.Atoi(beta)
-		if nil != err {
-			return false
+	case "i==", "i!=", "iprefix", "isuffix", "icontain", "iin":
+		// case insensitive variants
+		return s.match(strings.ToLower(alpha), operator[1:], strings.ToLower(beta))
+	case "regex", "iregex":
+		r := getPattern(operator, beta)
+		if nil != r && r.MatchString(alpha) {
+			return true
 		}
-		if alphaInt > betaInt {
+	case ">":
+		if result, ok := compareNumbers(alpha, beta); ok && 0 < result {
 			return true
 		}
 	case ">=":
-		alphaInt, err := strconv.Atoi(alpha)
-		if nil != err {
-			return false
-		}
-		betaInt, err := strconv.Atoi(beta)
-		if nil != err {
-			return false
-		}
-		if alphaInt >= betaInt {
+		if result, ok := compareNumbers(alpha, beta); ok && 0 <= result {
 			return true
 		}
 	case "<":
-		alphaInt, err := strconv.Atoi(alpha)
-		if nil != err {
-			return false
-		}
-		betaInt, err := strconv.Atoi(beta)
-		if nil != err {
-			return false
-		}
-		if alphaInt < betaInt {
+		if result, ok := compareNumbers(alpha, beta); ok && 0 > result {
 			return true
 		}
 	case "<=":
-		alphaInt, err := strconv.Atoi(alpha)
-		if nil != err {
-			return false
+		if result, ok := compareNumbers(alpha, beta); ok && 0 >= result {
+			return true
 		}
-		betaInt, err := strconv.Atoi(beta)
-		if nil != err {
-			return false
+	case "after":
+		if result, ok := compareTimes(alpha, beta); ok && 0 < result {
+			return true
 		}
-		if alphaInt <= betaInt {
+	case "before":
+		if result, ok := compareTimes(alpha, beta); ok && 0 > result {
 			return true
 		}
+	case "between":
+		return matchBetween(alpha, beta)
+	case "exists":
+		// missing properties are handled before
+		return true
+	case "notexists":
+		return false
 	case "in":
 		list := strings.Split(beta, ",")
 		for _, value := range list {
@@ -2413,10 +2688,70 @@ func (s *Storage) match(alpha string, operator string, beta string) bool {
 				return true
 			}
 		}
+	default:
+		return matchCustomOperator(alpha, operator, beta)
 	}
 	return false
 }
 
+// returns the value of a field ("ID", "Value", "Context", "Version"
+// or "Properties.name") of the given entity and if it exists
+func (s *Storage) getEntityField(entity types.StorageEntity, field string) (string, bool) {
+	switch field {
+	case "ID":
+		return strconv.Itoa(entity.ID), true
+	case "Value":
+		return entity.Value, true
+	case "Context":
+		return entity.Context, true
+	case "Version":
+		return strconv.Itoa(entity.Version), true
+	default:
+		if strings.HasPrefix(field, "Properties.") {
+			value, ok := entity.Properties[field[11:]]
+			return value, ok
+		}
+	}
+	return "", false
+}
+
+// copies the selected fields of an entity into a transport entity.
+// fields are "Value", "Context", "Properties" for all properties or
+// "Properties.name" for single ones. type, id and version are always
+// copied, an empty selection copies everything
+func (s *Storage) selectEntityFieldsUnsafe(entity types.StorageEntity, selection []string) transport.TransportEntity {
+	ret := transport.TransportEntity{
+		Type:    s.EntityTypes[entity.Type],
+		ID:      entity.ID,
+		Version: entity.Version,
+	}
+	if 0 == len(selection) {
+		ret.Value = entity.Value
+		ret.Context = entity.Context
+		selection = []string{"Properties"}
+	}
+	ret.Properties = make(map[string]string)
+	for _, field := range selection {
+		switch field {
+		case "Value":
+			ret.Value = entity.Value
+		case "Context":
+			ret.Context = entity.Context
+		case "Properties":
+			for key, value := range entity.Properties {
+				ret.Properties[key] = value
+			}
+		default:
+			if strings.HasPrefix(field, "Properties.") {
+				if value, ok := entity.Properties[field[11:]]; ok {
+					ret.Properties[field[11:]] = value
+				}
+			}
+		}
+	}
+	return ret
+}
+
 func (s *Storage) deepCopyEntity(entity types.StorageEntity) types.StorageEntity {
 	// first we copy the base values
 	newEntity := types.StorageEntity{
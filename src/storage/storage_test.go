@@ -0,0 +1,613 @@
+package storage
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/voodooEntity/gits/src/types"
+)
+
+// creates a storage holding a chain of "Task" entities
+// task1 -> task2 -> ... -> taskN and returns storage and type id
+func createTaskChain(amount int) (*Storage, int) {
+	store := NewStorage()
+	taskType, _ := store.CreateEntityType("Task")
+	for i := 1; i <= amount; i++ {
+		store.CreateEntity(types.StorageEntity{
+			Type:  taskType,
+			Value: "task",
+		})
+		if 1 < i {
+			store.CreateRelation(taskType, i-1, taskType, i, types.StorageRelation{
+				SourceType: taskType,
+				SourceID:   i - 1,
+				TargetType: taskType,
+				TargetID:   i,
+			})
+		}
+	}
+	return store, taskType
+}
+
+func TestDagModeRejectsCycle(t *testing.T) {
+	store, taskType := createTaskChain(3)
+	store.SetDagMode(true)
+
+	created, err := store.CreateRelation(taskType, 3, taskType, 1, types.StorageRelation{SourceType: taskType, SourceID: 3, TargetType: taskType, TargetID: 1})
+	if created || nil == err {
+		t.Error("relation closing a cycle should have been rejected")
+	}
+	created, err = store.CreateRelation(taskType, 1, taskType, 1, types.StorageRelation{SourceType: taskType, SourceID: 1, TargetType: taskType, TargetID: 1})
+	if created || nil == err {
+		t.Error("self relation should have been rejected")
+	}
+	created, err = store.CreateRelation(taskType, 1, taskType, 3, types.StorageRelation{SourceType: taskType, SourceID: 1, TargetType: taskType, TargetID: 3})
+	if !created || nil != err {
+		t.Error("relation not closing a cycle should have been created", err)
+	}
+	if 0 != store.LinkAddressLists([][2]int{{taskType, 3}}, [][2]int{{taskType, 2}}) {
+		t.Error("link closing a cycle should have been rejected")
+	}
+}
+
+func TestDagModeByType(t *testing.T) {
+	store, taskType := createTaskChain(2)
+	otherType, _ := store.CreateEntityType("Other")
+	store.CreateEntity(types.StorageEntity{Type: otherType, Value: "other"})
+	store.SetDagModeByType(taskType, true)
+
+	// cycles through a non enforced type are not checked
+	store.CreateRelation(taskType, 2, otherType, 1, types.StorageRelation{SourceType: taskType, SourceID: 2, TargetType: otherType, TargetID: 1})
+	created, _ := store.CreateRelation(otherType, 1, taskType, 1, types.StorageRelation{SourceType: otherType, SourceID: 1, TargetType: taskType, TargetID: 1})
+	if !created {
+		t.Error("relation from non enforced type should have been created")
+	}
+	created, _ = store.CreateRelation(taskType, 2, taskType, 1, types.StorageRelation{SourceType: taskType, SourceID: 2, TargetType: taskType, TargetID: 1})
+	if created {
+		t.Error("relation closing a cycle between enforced types should have been rejected")
+	}
+	if nil == store.SetDagModeByType(4242, true) {
+		t.Error("enabling dag mode for a non existing type should fail")
+	}
+}
+
+func TestFindCycles(t *testing.T) {
+	store, taskType := createTaskChain(4)
+	otherType, _ := store.CreateEntityType("Other")
+	store.CreateEntity(types.StorageEntity{Type: otherType, Value: "other"})
+	if 0 != len(store.FindCycles(nil)) {
+		t.Error("chain should not contain any cycle")
+	}
+
+	store.CreateRelation(taskType, 4, taskType, 2, types.StorageRelation{SourceType: taskType, SourceID: 4, TargetType: taskType, TargetID: 2})
+	store.CreateRelation(taskType, 1, otherType, 1, types.StorageRelation{SourceType: taskType, SourceID: 1, TargetType: otherType, TargetID: 1})
+	store.CreateRelation(otherType, 1, taskType, 1, types.StorageRelation{SourceType: otherType, SourceID: 1, TargetType: taskType, TargetID: 1})
+
+	cycles := store.FindCycles(nil)
+	if 2 != len(cycles) {
+		t.Error("expected 2 cycles", cycles)
+	}
+	cycles = store.FindCycles([]string{"Task"})
+	if 1 != len(cycles) || 3 != len(cycles[0]) || [2]int{taskType, 2} != cycles[0][0] || [2]int{taskType, 4} != cycles[0][2] {
+		t.Error("expected cycle task2 -> task3 -> task4", cycles)
+	}
+}
+
+func TestTopologicalOrder(t *testing.T) {
+	store, taskType := createTaskChain(3)
+	// task4 is required by task1, so it has to come first
+	store.CreateEntity(types.StorageEntity{Type: taskType, Value: "task"})
+	store.CreateRelation(taskType, 4, taskType, 1, types.StorageRelation{SourceType: taskType, SourceID: 4, TargetType: taskType, TargetID: 1})
+
+	order, err := store.TopologicalOrder("Task", "")
+	if nil != err || 4 != len(order) || 4 != order[0].ID || 1 != order[1].ID || 2 != order[2].ID || 3 != order[3].ID {
+		t.Error("unexpected order", order, err)
+	}
+
+	store.CreateRelation(taskType, 3, taskType, 4, types.StorageRelation{SourceType: taskType, SourceID: 3, TargetType: taskType, TargetID: 4})
+	if _, err = store.TopologicalOrder("Task", ""); nil == err {
+		t.Error("cyclic dependencies should return an error")
+	}
+	if _, err = store.TopologicalOrder("Unknown", ""); nil == err {
+		t.Error("non existing type should return an error")
+	}
+}
+
+func TestGetReadyEntities(t *testing.T) {
+	store, taskType := createTaskChain(3)
+	store.CreateEntity(types.StorageEntity{Type: taskType, Value: "task"})
+	store.CreateRelation(taskType, 4, taskType, 3, types.StorageRelation{SourceType: taskType, SourceID: 4, TargetType: taskType, TargetID: 3})
+	condition := [3]string{"Properties.state", "==", "done"}
+
+	ready, _ := store.GetReadyEntities("Task", "", condition)
+	if 2 != len(ready) || 1 != ready[0].ID || 4 != ready[1].ID {
+		t.Error("expected task1 and task4 to be ready", ready)
+	}
+
+	for _, id := range []int{1, 2} {
+		entity, _ := store.GetEntityByPath(taskType, id, "")
+		entity.Properties["state"] = "done"
+		store.UpdateEntity(entity)
+	}
+	ready, _ = store.GetReadyEntities("Task", "", condition)
+	if 1 != len(ready) || 4 != ready[0].ID {
+		t.Error("expected only task4 to be ready", ready)
+	}
+}
+
+// creates two routes between Account 1 and Device 1
+// short: Account -> Card -> Device
+// long:  Account -> Session -> Ip -> Device
+func createPathTestData() (*Storage, map[string]int) {
+	store := NewStorage()
+	typeIDs := make(map[string]int)
+	for _, name := range []string{"Account", "Card", "Session", "Ip", "Device"} {
+		typeIDs[name], _ = store.CreateEntityType(name)
+		store.CreateEntity(types.StorageEntity{Type: typeIDs[name], Value: name})
+	}
+	link := func(from string, to string, weight string) {
+		store.CreateRelation(typeIDs[from], 1, typeIDs[to], 1, types.StorageRelation{
+			SourceType: typeIDs[from],
+			SourceID:   1,
+			TargetType: typeIDs[to],
+			TargetID:   1,
+			Properties: map[string]string{"weight": weight},
+		})
+	}
+	link("Account", "Card", "10")
+	link("Card", "Device", "10")
+	link("Account", "Session", "1")
+	link("Session", "Ip", "1")
+	link("Ip", "Device", "1")
+	return store, typeIDs
+}
+
+func TestShortestPath(t *testing.T) {
+	store, typeIDs := createPathTestData()
+	from := [2]int{typeIDs["Account"], 1}
+	to := [2]int{typeIDs["Device"], 1}
+
+	path, err := store.ShortestPath(from, to, PathOptions{Direction: PATH_CHILD})
+	if nil != err || 3 != path.Amount || "Card" != path.Entities[1].Type || 2 != len(path.Relations) || "Account" != path.Relations[0].SourceType || "Card" != path.Relations[0].TargetType {
+		t.Error("expected path over Card", path, err)
+	}
+
+	path, err = store.ShortestPath(from, to, PathOptions{Direction: PATH_CHILD, Types: []string{"Session", "Ip"}})
+	if nil != err || 4 != path.Amount || "Session" != path.Entities[1].Type || "Ip" != path.Entities[2].Type {
+		t.Error("expected path over Session and Ip", path, err)
+	}
+
+	_, err = store.ShortestPath(from, to, PathOptions{Direction: PATH_CHILD, Types: []string{"Session", "Ip"}, MaxDepth: 2})
+	if nil == err {
+		t.Error("path should exceed max depth")
+	}
+
+	_, err = store.ShortestPath(from, to, PathOptions{Direction: PATH_PARENT})
+	if nil == err {
+		t.Error("there should be no path towards parents")
+	}
+	if _, err = store.ShortestPath(to, from, PathOptions{Direction: PATH_PARENT}); nil != err {
+		t.Error("expected the reverse path towards parents", err)
+	}
+	if _, err = store.ShortestPath(to, from, PathOptions{}); nil == err {
+		t.Error("options without direction should fail")
+	}
+	if _, err = store.FindPaths([][2]int{to}, [][2]int{from}, PathOptions{Direction: DIRECTION_PARENT}); nil == err {
+		t.Error("DIRECTION_PARENT is no valid path direction")
+	}
+
+	path, err = store.ShortestPath(to, from, PathOptions{Direction: PATH_BOTH})
+	if nil != err || 3 != path.Amount || "Device" != path.Entities[0].Type || "Card" != path.Entities[1].Type {
+		t.Error("expected path over Card ignoring directions", path, err)
+	}
+}
+
+func TestShortestPathWeighted(t *testing.T) {
+	store, typeIDs := createPathTestData()
+	from := [2]int{typeIDs["Account"], 1}
+	to := [2]int{typeIDs["Device"], 1}
+
+	path, err := store.ShortestPath(from, to, PathOptions{Direction: PATH_CHILD, WeightProperty: "weight"})
+	if nil != err || 4 != path.Amount || "Session" != path.Entities[1].Type {
+		t.Error("expected cheapest path over Session and Ip", path, err)
+	}
+
+	path, err = store.ShortestPath(from, to, PathOptions{Direction: PATH_CHILD, WeightProperty: "weight", MaxDepth: 2})
+	if nil != err || 3 != path.Amount || "Card" != path.Entities[1].Type {
+		t.Error("expected path over Card due to max depth", path, err)
+	}
+
+	for _, weight := range []string{"-1", "NaN", "Inf", "+Inf", "-Inf"} {
+		relation := store.RelationStorage[typeIDs["Account"]][1][typeIDs["Card"]][1]
+		relation.Properties = map[string]string{"weight": weight}
+		store.RelationStorage[typeIDs["Account"]][1][typeIDs["Card"]][1] = relation
+		if _, err = store.ShortestPath(from, to, PathOptions{Direction: PATH_CHILD, WeightProperty: "weight"}); nil == err {
+			t.Error("expected an error for the weight", weight)
+		}
+	}
+}
+
+func TestFindPathsOverTargets(t *testing.T) {
+	store, taskType := createTaskChain(4)
+
+	// task 4 is only reachable over task 2 which is a target itself
+	ret, err := store.FindPaths([][2]int{{taskType, 1}}, [][2]int{{taskType, 2}, {taskType, 4}}, PathOptions{Direction: PATH_CHILD})
+	if nil != err || 2 != ret.Amount || 2 != len(ret.Paths[0].Entities) || 4 != len(ret.Paths[1].Entities) || 4 != ret.Paths[1].Entities[3].ID {
+		t.Error("expected the paths to task 2 and over task 2 to task 4", ret, err)
+	}
+
+	ret, err = store.FindPaths([][2]int{{taskType, 1}}, [][2]int{{taskType, 2}, {taskType, 4}}, PathOptions{Direction: PATH_CHILD, MaxDepth: 2})
+	if nil != err || 1 != ret.Amount || 2 != ret.Paths[0].Entities[1].ID {
+		t.Error("expected only the path to task 2 within max depth", ret, err)
+	}
+
+	ret, err = store.FindPaths([][2]int{{taskType, 1}}, [][2]int{{taskType, 2}, {taskType, 4}}, PathOptions{Direction: PATH_CHILD, MaxPaths: 1})
+	if nil != err || 1 != ret.Amount || 2 != len(ret.Paths[0].Entities) {
+		t.Error("expected only the shortest path", ret, err)
+	}
+}
+
+func TestExtractSubgraph(t *testing.T) {
+	store, typeIDs := createPathTestData()
+
+	sub, _ := store.ExtractSubgraph([][2]int{{typeIDs["Card"], 1}}, 1, false)
+	if 3 != len(sub.EntityStorage[typeIDs["Account"]])+len(sub.EntityStorage[typeIDs["Card"]])+len(sub.EntityStorage[typeIDs["Device"]]) || 0 != len(sub.EntityStorage[typeIDs["Session"]]) {
+		t.Error("expected account, card and device to be extracted", sub.EntityStorage)
+	}
+	if !sub.RelationExists(typeIDs["Account"], 1, typeIDs["Card"], 1) || !sub.RelationExists(typeIDs["Card"], 1, typeIDs["Device"], 1) || "10" != sub.RelationStorage[typeIDs["Card"]][1][typeIDs["Device"]][1].Properties["weight"] {
+		t.Error("expected relations between extracted entities to be copied", sub.RelationStorage)
+	}
+	if 0 != len(sub.RelationStorage[typeIDs["Account"]][1][typeIDs["Session"]]) {
+		t.Error("relations to not extracted entities should be skipped")
+	}
+
+	tasks, taskType := createTaskChain(4)
+	sub, addressMap := tasks.ExtractSubgraph([][2]int{{taskType, 3}}, 1, false)
+	if 3 != len(sub.EntityStorage[taskType]) || !sub.EntityExists(taskType, 4) || [2]int{taskType, 2} != addressMap[[2]int{taskType, 2}] {
+		t.Error("expected tasks 2 to 4 with their ids", sub.EntityStorage)
+	}
+	if id, _ := sub.CreateEntity(types.StorageEntity{Type: taskType}); 5 != id {
+		t.Error("new entities should not reuse ids of the source storage", id)
+	}
+
+	sub, addressMap = tasks.ExtractSubgraph([][2]int{{taskType, 3}}, 1, true)
+	if 3 != len(sub.EntityStorage[taskType]) || [2]int{taskType, 1} != addressMap[[2]int{taskType, 2}] || !sub.RelationExists(taskType, 2, taskType, 3) || sub.RelationExists(taskType, 3, taskType, 4) {
+		t.Error("expected tasks to be remapped to ids 1 to 3", sub.EntityStorage, addressMap)
+	}
+}
+
+// creates a crawler session graph Host -> Page with the given page property
+func createSessionGraph(host string, page string, status string) *Storage {
+	store := NewStorage()
+	hostType, _ := store.CreateEntityType("Host")
+	pageType, _ := store.CreateEntityType("Page")
+	hostID, _ := store.CreateEntity(types.StorageEntity{Type: hostType, Value: host, Properties: map[string]string{"url": "https://" + host}})
+	pageID, _ := store.CreateEntity(types.StorageEntity{Type: pageType, Value: page, Properties: map[string]string{"status": status}})
+	store.CreateRelation(hostType, hostID, pageType, pageID, types.StorageRelation{SourceType: hostType, SourceID: hostID, TargetType: pageType, TargetID: pageID, Properties: map[string]string{"status": status}})
+	return store
+}
+
+func TestMerge(t *testing.T) {
+	store := NewStorage()
+	// a different type id order than in the session graphs
+	store.CreateEntityType("Other")
+
+	addressMap, err := store.Merge(createSessionGraph("example.com", "/index", "200"), MergePolicy{Key: MERGE_KEY_VALUE_CONTEXT})
+	if nil != err || 2 != len(addressMap) || [2]int{2, 1} != addressMap[[2]int{1, 1}] {
+		t.Error("expected entities to be imported with mapped types", addressMap, err)
+	}
+
+	store.Merge(createSessionGraph("example.com", "/index", "404"), MergePolicy{Key: MERGE_KEY_VALUE_CONTEXT, Mode: MERGE_KEEP_EXISTING})
+	page, _ := store.GetEntityByPath(3, 1, "")
+	if 1 != len(store.EntityStorage[2]) || 1 != len(store.EntityStorage[3]) || "200" != page.Properties["status"] {
+		t.Error("duplicates should have been kept as they are", store.EntityStorage)
+	}
+
+	store.Merge(createSessionGraph("example.com", "/index", "404"), MergePolicy{Key: MERGE_KEY_VALUE_CONTEXT, Mode: MERGE_OVERWRITE})
+	page, _ = store.GetEntityByPath(3, 1, "")
+	relation, _ := store.GetRelation(2, 1, 3, 1)
+	if "404" != page.Properties["status"] || 2 != page.Version || "404" != relation.Properties["status"] {
+		t.Error("duplicates should have been overwritten", page, relation)
+	}
+
+	// hosts have no status property so they are never duplicates
+	store.Merge(createSessionGraph("other.com", "/index", "200"), MergePolicy{Key: MERGE_KEY_PROPERTY, Property: "status", Mode: MERGE_PROPERTIES})
+	if 2 != len(store.EntityStorage[2]) || 2 != len(store.EntityStorage[3]) || !store.RelationExists(2, 2, 3, 2) {
+		t.Error("entities without a duplicate key should have been created", store.EntityStorage)
+	}
+
+	store.Merge(createSessionGraph("next.com", "/next", "404"), MergePolicy{Key: MERGE_KEY_PROPERTY, Property: "status", Mode: MERGE_PROPERTIES})
+	page, _ = store.GetEntityByPath(3, 1, "")
+	if 3 != len(store.EntityStorage[2]) || 2 != len(store.EntityStorage[3]) || "/index" != page.Value || !store.RelationExists(2, 3, 3, 1) {
+		t.Error("page should have been matched by property", store.EntityStorage)
+	}
+
+	if _, err = store.Merge(store, MergePolicy{}); nil == err {
+		t.Error("merging a storage into itself should fail")
+	}
+}
+
+func TestMergeDuplicateLocalKeys(t *testing.T) {
+	store := NewStorage()
+	hostType, _ := store.CreateEntityType("Host")
+	for i := 0; i < 20; i++ {
+		store.CreateEntity(types.StorageEntity{Type: hostType, Value: "example.com"})
+	}
+	// several local entities share the key, the lowest id has to win every time
+	for i := 0; i < 10; i++ {
+		addressMap, err := store.Merge(createSessionGraph("example.com", "/index", "200"), MergePolicy{Key: MERGE_KEY_VALUE_CONTEXT, Mode: MERGE_OVERWRITE})
+		if nil != err || [2]int{hostType, 1} != addressMap[[2]int{1, 1}] {
+			t.Error("expected the host to be merged into the entity with the lowest id", addressMap, err)
+		}
+	}
+}
+
+func TestDiffAndApplyChangeSet(t *testing.T) {
+	for _, identity := range []int{DIFF_BY_ID, DIFF_BY_VALUE_CONTEXT} {
+		alpha := createSessionGraph("example.com", "/index", "200")
+		beta := createSessionGraph("example.com", "/index", "404")
+		// beta gets an additional page linked to the host
+		// and the relation to the first page is changed
+		pageID, _ := beta.CreateEntity(types.StorageEntity{Type: 2, Value: "/about"})
+		beta.CreateRelation(1, 1, 2, pageID, types.StorageRelation{SourceType: 1, SourceID: 1, TargetType: 2, TargetID: pageID})
+		// alpha holds a host beta doesn't know
+		alpha.CreateEntity(types.StorageEntity{Type: 1, Value: "old.com"})
+
+		cs := Diff(alpha, beta, identity)
+		if 1 != len(cs.AddedEntities) || 1 != len(cs.RemovedEntities) || 1 != len(cs.ChangedEntities) || 1 != len(cs.AddedRelations) || 0 != len(cs.RemovedRelations) || 1 != len(cs.ChangedRelations) {
+			t.Error("unexpected change set", identity, cs)
+		}
+
+		// the change set has to survive serialization
+		data, err := json.Marshal(cs)
+		if nil != err {
+			t.Error(err)
+		}
+		var decoded ChangeSet
+		json.Unmarshal(data, &decoded)
+
+		if err = ApplyChangeSet(alpha, decoded); nil != err {
+			t.Error("change set should apply without errors", identity, err)
+		}
+		cs = Diff(alpha, beta, identity)
+		if 0 != len(cs.AddedEntities)+len(cs.RemovedEntities)+len(cs.ChangedEntities)+len(cs.AddedRelations)+len(cs.RemovedRelations)+len(cs.ChangedRelations) {
+			t.Error("storages should be equal after applying the change set", identity, cs)
+		}
+		if err = ApplyChangeSet(alpha, decoded); nil == err {
+			t.Error("applying the change set twice should report errors", identity)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	clock := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	historyNow = func() time.Time { return clock }
+	defer func() { historyNow = time.Now }()
+
+	// entities existing before enabling are valid since ever
+	store, taskType := createTaskChain(2)
+	store.EnableHistory(taskType, HistoryConfig{MaxVersions: 3})
+	for i := 1; i <= 3; i++ {
+		clock = clock.Add(time.Hour)
+		entity, _ := store.GetEntityByPath(taskType, 1, "")
+		entity.Value = "v" + strconv.Itoa(i)
+		store.UpdateEntity(entity)
+	}
+
+	if _, err := store.GetEntityAtVersion(taskType, 1, 1); nil == err {
+		t.Error("version 1 should have been dropped")
+	}
+	if entity, err := store.GetEntityAtVersion(taskType, 1, 2); nil != err || "v1" != entity.Value {
+		t.Error("expected version 2 with value v1", entity, err)
+	}
+	if entity, err := store.GetEntityAsOf(taskType, 1, clock.Add(-30*time.Minute)); nil != err || "v2" != entity.Value {
+		t.Error("expected value v2 at 14:30", entity, err)
+	}
+
+	clock = clock.Add(time.Hour)
+	store.DeleteEntity(taskType, 2)
+	snapshot := store.SnapshotAsOf(clock.Add(-30 * time.Minute))
+	if !snapshot.EntityExists(taskType, 2) || !snapshot.RelationExists(taskType, 1, taskType, 2) || "v3" != snapshot.EntityStorage[taskType][1].Value {
+		t.Error("expected task2 and its relation to exist before deletion", snapshot.EntityStorage, snapshot.RelationStorage)
+	}
+	snapshot = store.SnapshotAsOf(clock)
+	if snapshot.EntityExists(taskType, 2) || snapshot.RelationExists(taskType, 1, taskType, 2) {
+		t.Error("task2 should not exist after deletion")
+	}
+	if entity, err := store.GetTransportEntityAtVersionUnsafe(taskType, 1, 2, "Value"); nil != err || "v1" != entity.Value || 2 != entity.Version || 0 != len(entity.Properties) {
+		t.Error("expected only the value of task1 in version 2", entity, err)
+	}
+
+	// versions replaced longer than an hour ago are dropped
+	store.EnableHistory(taskType, HistoryConfig{MaxAge: time.Hour})
+	clock = clock.Add(2 * time.Hour)
+	entity, _ := store.GetEntityByPath(taskType, 1, "")
+	store.UpdateEntity(entity)
+	if 2 != len(store.EntityHistory[[2]int{taskType, 1}]) {
+		t.Error("expected only the last two versions to be kept", store.EntityHistory)
+	}
+}
+
+func TestUnsetProperties(t *testing.T) {
+	store := NewStorage()
+	typeID, _ := store.CreateEntityType("Host")
+	alpha, _ := store.CreateEntity(types.StorageEntity{Type: typeID, Value: "alpha", Properties: map[string]string{"a": "1", "b": "2", "c": "3"}})
+	beta, _ := store.CreateEntity(types.StorageEntity{Type: typeID, Value: "beta"})
+	store.CreateRelation(typeID, alpha, typeID, beta, types.StorageRelation{SourceType: typeID, SourceID: alpha, TargetType: typeID, TargetID: beta, Properties: map[string]string{"weight": "1", "label": "x"}})
+
+	if nil != store.UnsetEntityProperties(typeID, alpha, "a", "missing") {
+		t.Error("expected properties to be unset")
+	}
+	entity, _ := store.GetEntityByPath(typeID, alpha, "")
+	if 2 != len(entity.Properties) || 2 != entity.Version {
+		t.Error("unexpected entity after unset", entity)
+	}
+	store.ClearEntityProperties(typeID, alpha)
+	entity, _ = store.GetEntityByPath(typeID, alpha, "")
+	if 0 != len(entity.Properties) {
+		t.Error("expected all entity properties to be removed", entity)
+	}
+
+	store.UnsetRelationProperties(typeID, alpha, typeID, beta, "label")
+	relation, _ := store.GetRelation(typeID, alpha, typeID, beta)
+	if 1 != len(relation.Properties) || "1" != relation.Properties["weight"] {
+		t.Error("unexpected relation after unset", relation)
+	}
+	store.ClearRelationProperties(typeID, alpha, typeID, beta)
+	relation, _ = store.GetRelation(typeID, alpha, typeID, beta)
+	if 0 != len(relation.Properties) {
+		t.Error("expected all relation properties to be removed", relation)
+	}
+
+	// updates with nil property maps must not break later updates
+	entity, _ = store.GetEntityByPath(typeID, beta, "")
+	entity.Properties = nil
+	store.UpdateEntity(entity)
+	if 0 != len(store.BatchUpdateAddressList([][2]int{{typeID, beta}}, map[string]string{"Properties.x": "1"})) {
+		t.Error("expected batch update on entity without properties to succeed")
+	}
+	if nil == store.UnsetEntityProperties(typeID, 99) {
+		t.Error("expected error for missing entity")
+	}
+}
+
+func TestMatchOperators(t *testing.T) {
+	store := NewStorage()
+	for _, test := range []struct {
+		alpha    string
+		operator string
+		beta     string
+		expected bool
+	}{
+		{"abbc", "regex", "^ab+c$", true},
+		{"ABC", "regex", "^ab+c$", false},
+		{"ABC", "iregex", "^ab+c$", true},
+		{"abc", "regex", "(", false},
+		{"Berlin", "i==", "berlin", true},
+		{"Berlin", "i!=", "BERLIN", false},
+		{"Berlin", "iprefix", "BER", true},
+		{"Berlin", "isuffix", "LIN", true},
+		{"Berlin", "icontain", "RLI", true},
+		{"Berlin", "iin", "paris,BERLIN", true},
+		{"10.5", ">", "3", true},
+		{"10.5", "<=", "10.50", true},
+		{"2.99", "<", "3", true},
+		{"12", ">=", "12", true},
+		{"abc", ">", "1", false},
+		{"2024-05-01T10:00:00Z", "after", "2024-05-01T09:00:00Z", true},
+		{"2024-05-01T10:00:00+02:00", "before", "2024-05-01T09:00:00Z", true},
+		{"yesterday", "before", "2024-05-01T09:00:00Z", false},
+		{"15", "between", "10,20", true},
+		{"20", "between", "10,20", true},
+		{"20.5", "between", "10,20", false},
+		{"2024-05-15T00:00:00Z", "between", "2024-05-01T00:00:00Z,2024-06-01T00:00:00Z", true},
+		{"2024-07-01T00:00:00Z", "between", "2024-05-01T00:00:00Z,2024-06-01T00:00:00Z", false},
+		{"x", "exists", "", true},
+		{"x", "notexists", "", false},
+	} {
+		if test.expected != store.match(test.alpha, test.operator, test.beta) {
+			t.Error("unexpected result", test.alpha, test.operator, test.beta)
+		}
+	}
+}
+
+func TestPatternCache(t *testing.T) {
+	if getPattern("regex", "^ab+c$") != getPattern("regex", "^ab+c$") {
+		t.Error("Pattern is compiled again instead of using the cache.")
+	}
+	if getPattern("regex", "^ab+c$") == getPattern("iregex", "^ab+c$") {
+		t.Error("regex and iregex share the same compiled pattern.")
+	}
+	if nil != getPattern("regex", "(") {
+		t.Error("Invalid pattern is compiled.")
+	}
+	for i := 0; i <= patternCacheSize; i++ {
+		getPattern("regex", strconv.Itoa(i))
+	}
+	patternMutex.RLock()
+	size := len(patternCache)
+	patternMutex.RUnlock()
+	if patternCacheSize < size {
+		t.Error("Pattern cache grows beyond its size.")
+	}
+}
+
+func TestCustomOperatorsAndTransforms(t *testing.T) {
+	if nil == RegisterOperator("==", func(alpha string, beta string) bool { return true }) {
+		t.Error("expected builtin operators not to be overwritten")
+	}
+	if nil == RegisterTransform("a|b", func(value string, argument string) (string, bool) { return value, true }) {
+		t.Error("expected invalid transform names to fail")
+	}
+	err := RegisterOperator("cidr", func(alpha string, beta string) bool {
+		_, network, err := net.ParseCIDR(beta)
+		return nil == err && network.Contains(net.ParseIP(alpha))
+	})
+	if nil != err {
+		t.Error(err)
+	}
+	err = RegisterTransform("repeat", func(value string, argument string) (string, bool) {
+		amount, err := strconv.Atoi(argument)
+		if nil != err {
+			return "", false
+		}
+		return strings.Repeat(value, amount), true
+	})
+	if nil != err {
+		t.Error(err)
+	}
+
+	store := NewStorage()
+	for _, test := range []struct {
+		value     string
+		condition [3]string
+		expected  bool
+	}{
+		{"10.0.1.7", [3]string{"Value", "cidr", "10.0.0.0/16"}, true},
+		{"10.1.1.7", [3]string{"Value", "cidr", "10.0.0.0/16"}, false},
+		{"x", [3]string{"Value", "unknown", "x"}, false},
+		{"BeRlIn", [3]string{"Value|lower", "==", "berlin"}, true},
+		{"grüße", [3]string{"Value|len", "==", "5"}, true},
+		{`{"address":{"city":"berlin","zip":10115},"tags":["a","b"]}`, [3]string{"Properties.meta|json:address.city", "==", "berlin"}, true},
+		{`{"address":{"city":"berlin","zip":10115},"tags":["a","b"]}`, [3]string{"Properties.meta|json:address.zip", ">", "10000"}, true},
+		{`{"address":{"city":"berlin","zip":10115},"tags":["a","b"]}`, [3]string{"Properties.meta|json:tags.1", "==", "b"}, true},
+		{`{"address":{"city":"berlin","zip":10115},"tags":["a","b"]}`, [3]string{"Properties.meta|json:tags|len", "==", "9"}, true},
+		{`{"address":{"city":"berlin","zip":10115},"tags":["a","b"]}`, [3]string{"Properties.meta|json:missing", "notexists", ""}, false},
+		{"no json", [3]string{"Properties.meta|json:a", "!=", "x"}, false},
+		{"ab", [3]string{"Value|repeat:2", "==", "abab"}, true},
+		{"ab", [3]string{"Value|unknown", "==", "ab"}, false},
+	} {
+		if test.expected != store.matchCondition(test.value, test.condition) {
+			t.Error("unexpected result", test.value, test.condition)
+		}
+	}
+
+	UnregisterOperator("cidr")
+	UnregisterTransform("repeat")
+	if store.matchCondition("10.0.1.7", [3]string{"Value", "cidr", "10.0.0.0/16"}) || store.matchCondition("ab", [3]string{"Value|repeat:2", "==", "abab"}) {
+		t.Error("expected unregistered operators and transforms not to match")
+	}
+}
+
+func TestQueryFilterTree(t *testing.T) {
+	store := NewStorage()
+	typeID, _ := store.CreateEntityType("Host")
+	store.CreateEntity(types.StorageEntity{Type: typeID, Value: "alpha"})
+	store.CreateEntity(types.StorageEntity{Type: typeID, Value: "beta"})
+	conditions := [][][3]string{{{"Value", "==", "alpha"}}}
+	valueFilter := [][]int{{0}}
+
+	_, addresses, _ := store.GetEntitiesByQueryFilter([]string{"Host"}, conditions, [][]int{{}}, valueFilter, [][]int{{}}, []map[string][]int{{}}, false)
+	if 1 != len(addresses) || 1 != addresses[0][1] {
+		t.Error("expected only alpha", addresses)
+	}
+	tree := &ConditionNode{Type: CONDITION_NOT, Children: []ConditionNode{{Type: CONDITION_GROUP, Group: 0}}}
+	_, addresses, _ = store.GetEntitiesByQueryFilterTree([]string{"Host"}, conditions, [][]int{{}}, valueFilter, [][]int{{}}, []map[string][]int{{}}, tree, false)
+	if 1 != len(addresses) || 2 != addresses[0][1] {
+		t.Error("expected only beta", addresses)
+	}
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"github.com/voodooEntity/gits/src/types"
+)
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// copies the given root entities and everything reachable from them
+// within depth relations (following relations in both directions)
+// into a new storage. all relations between copied entities are
+// copied too. entity types keep their ids and dag settings are
+// taken over. if remapIDs is false entities keep their ids, else
+// they get new ids per type in order of their old ids. returns the
+// new storage and a map translating the old addresses into the new ones
+func (s *Storage) ExtractSubgraph(roots [][2]int, depth int, remapIDs bool) (*Storage, map[[2]int][2]int) {
+	s.EntityTypeMutex.RLock()
+	s.EntityStorageMutex.RLock()
+	s.RelationStorageMutex.RLock()
+	ret, addressMap := s.ExtractSubgraphUnsafe(roots, depth, remapIDs)
+	s.RelationStorageMutex.RUnlock()
+	s.EntityStorageMutex.RUnlock()
+	s.EntityTypeMutex.RUnlock()
+	return ret, addressMap
+}
+
+func (s *Storage) ExtractSubgraphUnsafe(roots [][2]int, depth int, remapIDs bool) (*Storage, map[[2]int][2]int) {
+	// collect the reached entities level by level
+	reached := make(map[[2]int]bool)
+	var frontier [][2]int
+	for _, root := range roots {
+		if s.EntityExistsUnsafe(root[0], root[1]) && !reached[root] {
+			reached[root] = true
+			frontier = append(frontier, root)
+		}
+	}
+	for level := 0; level < depth && 0 < len(frontier); level++ {
+		var next [][2]int
+		for _, address := range frontier {
+			related := append(s.getChildAddressesUnsafe(address, nil), s.getParentAddressesUnsafe(address, nil)...)
+			for _, relatedAddress := range related {
+				if !reached[relatedAddress] {
+					reached[relatedAddress] = true
+					next = append(next, relatedAddress)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	// copy all entity types so type ids stay the same
+	ret := NewStorage()
+	for typeID, name := range s.EntityTypes {
+		ret.EntityTypes[typeID] = name
+		ret.EntityRTypes[name] = typeID
+		ret.EntityStorage[typeID] = make(map[int]types.StorageEntity)
+		ret.RelationStorage[typeID] = make(map[int]map[int]map[int]types.StorageRelation)
+		ret.RelationRStorage[typeID] = make(map[int]map[int]map[int]bool)
+		ret.EntityIDMax[typeID] = 0
+		if !remapIDs {
+			ret.EntityIDMax[typeID] = s.EntityIDMax[typeID]
+		}
+	}
+	ret.EntityTypeIDMax = s.EntityTypeIDMax
+	ret.DagMode = s.DagMode
+	for typeID := range s.DagTypes {
+		ret.DagTypes[typeID] = true
+	}
+
+	// now the entities, sorted so remapped ids follow the old order
+	addresses := make([][2]int, 0, len(reached))
+	for address := range reached {
+		addresses = append(addresses, address)
+	}
+	sortAddresses(addresses)
+	addressMap := make(map[[2]int][2]int, len(addresses))
+	for _, address := range addresses {
+		entity := s.deepCopyEntity(s.EntityStorage[address[0]][address[1]])
+		if remapIDs {
+			ret.EntityIDMax[address[0]]++
+			entity.ID = ret.EntityIDMax[address[0]]
+		}
+		ret.EntityStorage[entity.Type][entity.ID] = entity
+		ret.RelationStorage[entity.Type][entity.ID] = make(map[int]map[int]types.StorageRelation)
+		ret.RelationRStorage[entity.Type][entity.ID] = make(map[int]map[int]bool)
+		addressMap[address] = [2]int{entity.Type, entity.ID}
+	}
+
+	// and finally all relations between copied entities
+	for _, address := range addresses {
+		source := addressMap[address]
+		for _, child := range s.getChildAddressesUnsafe(address, nil) {
+			target, ok := addressMap[child]
+			if !ok {
+				continue
+			}
+			relation := s.deepCopyRelation(s.RelationStorage[address[0]][address[1]][child[0]][child[1]])
+			relation.SourceType, relation.SourceID = source[0], source[1]
+			relation.TargetType, relation.TargetID = target[0], target[1]
+			if _, ok := ret.RelationStorage[source[0]][source[1]][target[0]]; !ok {
+				ret.RelationStorage[source[0]][source[1]][target[0]] = make(map[int]types.StorageRelation)
+			}
+			if _, ok := ret.RelationRStorage[target[0]][target[1]][source[0]]; !ok {
+				ret.RelationRStorage[target[0]][target[1]][source[0]] = make(map[int]bool)
+			}
+			ret.RelationStorage[source[0]][source[1]][target[0]][target[1]] = relation
+			ret.RelationRStorage[target[0]][target[1]][source[0]][source[1]] = true
+		}
+	}
+
+	return ret, addressMap
+}
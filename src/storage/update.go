@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gits/src/types"
+)
+
+// operations to modify entity fields based on their current value.
+// each operation is given as [method, field, arguments...]
+const (
+	// [UPDATE_INCREMENT, field, delta] adds delta to the numeric
+	// value of the field, an empty field counts as 0
+	UPDATE_INCREMENT = "Increment"
+	// [UPDATE_APPEND, field, suffix] appends suffix to the field
+	UPDATE_APPEND = "Append"
+	// [UPDATE_SET_IF_EMPTY, field, value] sets the field only
+	// if it is empty or doesnt exist
+	UPDATE_SET_IF_EMPTY = "SetIfEmpty"
+	// [UPDATE_COMPARE_AND_SET, field, expected, value] sets the field
+	// only if it currently equals expected, fails otherwise
+	UPDATE_COMPARE_AND_SET = "CompareAndSet"
+	// [UPDATE_UNSET, field] removes a property or empties value
+	// and context. using "Properties" as field removes all properties
+	UPDATE_UNSET = "Unset"
+)
+
+// updates all given addresses by setting the given values and
+// applying the given operations in order afterwards. entities
+// having an expected version in versions are only updated if their
+// current version matches. if a single operation fails the entity
+// is left unchanged. returns the error per address that could
+// not be updated
+func (s *Storage) BatchModifyAddressList(addressList [][2]int, values map[string]string, operations [][]string, versions map[[2]int]int) map[[2]int]error {
+	failed := make(map[[2]int]error)
+	for _, address := range addressList {
+		entity, err := s.modifyEntityUnsafe(address, values, operations, versions)
+		if nil == err {
+			err = s.UpdateEntityUnsafe(entity)
+		}
+		if nil != err {
+			failed[address] = err
+		}
+	}
+	return failed
+}
+
+// returns the entities of the given addresses in the state
+// BatchModifyAddressList would leave them in without changing
+// anything. returns the error per address that could not be updated
+func (s *Storage) PreviewModifyAddressListUnsafe(addressList [][2]int, values map[string]string, operations [][]string, versions map[[2]int]int) ([]transport.TransportEntity, map[[2]int]error) {
+	var ret []transport.TransportEntity
+	failed := make(map[[2]int]error)
+	for _, address := range addressList {
+		entity, err := s.modifyEntityUnsafe(address, values, operations, versions)
+		if nil != err {
+			failed[address] = err
+			continue
+		}
+		ret = append(ret, transport.TransportEntity{
+			Type:       s.EntityTypes[entity.Type],
+			ID:         entity.ID,
+			Value:      entity.Value,
+			Context:    entity.Context,
+			Version:    entity.Version + 1,
+			Properties: entity.Properties,
+		})
+	}
+	return ret, failed
+}
+
+// removes the given properties from an entity
+func (s *Storage) UnsetEntityProperties(Type int, id int, keys ...string) error {
+	s.EntityStorageMutex.Lock()
+	err := s.UnsetEntityPropertiesUnsafe(Type, id, keys...)
+	s.EntityStorageMutex.Unlock()
+	return err
+}
+
+func (s *Storage) UnsetEntityPropertiesUnsafe(Type int, id int, keys ...string) error {
+	entity, err := s.GetEntityByPathUnsafe(Type, id, "")
+	if nil != err {
+		return err
+	}
+	for _, key := range keys {
+		delete(entity.Properties, key)
+	}
+	return s.UpdateEntityUnsafe(entity)
+}
+
+// removes all properties of an entity
+func (s *Storage) ClearEntityProperties(Type int, id int) error {
+	s.EntityStorageMutex.Lock()
+	err := s.ClearEntityPropertiesUnsafe(Type, id)
+	s.EntityStorageMutex.Unlock()
+	return err
+}
+
+func (s *Storage) ClearEntityPropertiesUnsafe(Type int, id int) error {
+	entity, err := s.GetEntityByPathUnsafe(Type, id, "")
+	if nil != err {
+		return err
+	}
+	entity.Properties = make(map[string]string)
+	return s.UpdateEntityUnsafe(entity)
+}
+
+// removes the given properties from a relation
+func (s *Storage) UnsetRelationProperties(srcType int, srcID int, targetType int, targetID int, keys ...string) error {
+	s.RelationStorageMutex.Lock()
+	err := s.UnsetRelationPropertiesUnsafe(srcType, srcID, targetType, targetID, keys...)
+	s.RelationStorageMutex.Unlock()
+	return err
+}
+
+func (s *Storage) UnsetRelationPropertiesUnsafe(srcType int, srcID int, targetType int, targetID int, keys ...string) error {
+	relation, err := s.GetRelationUnsafe(srcType, srcID, targetType, targetID)
+	if nil != err {
+		return err
+	}
+	for _, key := range keys {
+		delete(relation.Properties, key)
+	}
+	_, err = s.UpdateRelationUnsafe(srcType, srcID, targetType, targetID, relation)
+	return err
+}
+
+// removes all properties of a relation
+func (s *Storage) ClearRelationProperties(srcType int, srcID int, targetType int, targetID int) error {
+	s.RelationStorageMutex.Lock()
+	err := s.ClearRelationPropertiesUnsafe(srcType, srcID, targetType, targetID)
+	s.RelationStorageMutex.Unlock()
+	return err
+}
+
+func (s *Storage) ClearRelationPropertiesUnsafe(srcType int, srcID int, targetType int, targetID int) error {
+	relation, err := s.GetRelationUnsafe(srcType, srcID, targetType, targetID)
+	if nil != err {
+		return err
+	}
+	relation.Properties = make(map[string]string)
+	_, err = s.UpdateRelationUnsafe(srcType, srcID, targetType, targetID, relation)
+	return err
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// + + + + + + + + + +  PRIVATE  + + + + + + + + + + +
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// returns a modified copy of the entity on the given address
+// without storing it
+func (s *Storage) modifyEntityUnsafe(address [2]int, values map[string]string, operations [][]string, versions map[[2]int]int) (types.StorageEntity, error) {
+	entity, err := s.GetEntityByPathUnsafe(address[0], address[1], "")
+	if nil != err {
+		return entity, err
+	}
+	if version, ok := versions[address]; ok && version != entity.Version {
+		return entity, errors.New("Mismatch of version.")
+	}
+	for key, value := range values {
+		s.setEntityField(&entity, key, value)
+	}
+	for _, operation := range operations {
+		if err = s.applyUpdateOperation(&entity, operation); nil != err {
+			return entity, err
+		}
+	}
+	return entity, nil
+}
+
+func (s *Storage) applyUpdateOperation(entity *types.StorageEntity, operation []string) error {
+	if 2 == len(operation) && UPDATE_UNSET == operation[0] {
+		if !s.unsetEntityField(entity, operation[1]) {
+			return errors.New("Field " + operation[1] + " cant be unset.")
+		}
+		return nil
+	}
+	if 3 > len(operation) {
+		return errors.New("Invalid update operation.")
+	}
+	current, _ := s.getEntityField(*entity, operation[1])
+
+	var value string
+	switch operation[0] {
+	case UPDATE_INCREMENT:
+		sum, err := addNumbers(current, operation[2])
+		if nil != err {
+			return err
+		}
+		value = sum
+	case UPDATE_APPEND:
+		value = current + operation[2]
+	case UPDATE_SET_IF_EMPTY:
+		if "" != current {
+			return nil
+		}
+		value = operation[2]
+	case UPDATE_COMPARE_AND_SET:
+		if 4 != len(operation) {
+			return errors.New("Invalid update operation.")
+		}
+		if current != operation[2] {
+			return errors.New("Mismatch of value.")
+		}
+		value = operation[3]
+	default:
+		return errors.New("Unknown update operation " + operation[0])
+	}
+
+	if !s.setEntityField(entity, operation[1], value) {
+		return errors.New("Field " + operation[1] + " cant be updated.")
+	}
+	return nil
+}
+
+func (s *Storage) setEntityField(entity *types.StorageEntity, field string, value string) bool {
+	switch field {
+	case "Value":
+		entity.Value = value
+	case "Context":
+		entity.Context = value
+	default:
+		if !strings.HasPrefix(field, "Properties.") {
+			return false
+		}
+		if nil == entity.Properties {
+			entity.Properties = make(map[string]string)
+		}
+		entity.Properties[field[11:]] = value
+	}
+	return true
+}
+
+func (s *Storage) unsetEntityField(entity *types.StorageEntity, field string) bool {
+	switch field {
+	case "Value":
+		entity.Value = ""
+	case "Context":
+		entity.Context = ""
+	case "Properties":
+		entity.Properties = make(map[string]string)
+	default:
+		if !strings.HasPrefix(field, "Properties.") {
+			return false
+		}
+		delete(entity.Properties, field[11:])
+	}
+	return true
+}
+
+// adds two numeric strings. integers stay integers, as soon
+// as one of them is a decimal the sum is a decimal too
+func addNumbers(alpha string, beta string) (string, error) {
+	if "" == alpha {
+		alpha = "0"
+	}
+	alphaInt, alphaErr := strconv.Atoi(alpha)
+	betaInt, betaErr := strconv.Atoi(beta)
+	if nil == alphaErr && nil == betaErr {
+		return strconv.Itoa(alphaInt + betaInt), nil
+	}
+	alphaFloat, err := strconv.ParseFloat(alpha, 64)
+	if nil != err {
+		return "", errors.New("Cant increment non numeric value " + alpha)
+	}
+	betaFloat, err := strconv.ParseFloat(beta, 64)
+	if nil != err {
+		return "", errors.New("Cant increment by non numeric value " + beta)
+	}
+	return strconv.FormatFloat(alphaFloat+betaFloat, 'f', -1, 64), nil
+}
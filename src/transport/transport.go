@@ -1,6 +1,8 @@
 package transport
 
 import (
+	"encoding/csv"
+	"io"
 	"strconv"
 	"strings"
 )
@@ -8,7 +10,12 @@ import (
 type Transport struct {
 	Entities  []TransportEntity
 	Relations []TransportRelation
+	Paths     []TransportPath
+	Failed    []TransportFailure
 	Amount    int
+	Cursor    string
+	Columns   []string
+	Rows      [][]string
 }
 
 type TransportEntity struct {
@@ -33,11 +40,40 @@ type TransportRelation struct {
 	Version    int
 }
 
+// ordered entities of a path from start to end and the
+// relations between them, Relations[i] connects Entities[i]
+// and Entities[i+1]
+type TransportPath struct {
+	Entities  []TransportEntity
+	Relations []TransportRelation
+}
+
+// entity a query could not be applied to. Version holds
+// the current version of the entity in the storage
+type TransportFailure struct {
+	Type    string
+	ID      int
+	Version int
+	Error   string
+}
+
 func New() *Transport {
 	tmp := Transport{}
 	return &tmp
 }
 
+// writes the columns and rows of a flat result as csv
+func (self *Transport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(self.Columns); nil != err {
+		return err
+	}
+	if err := writer.WriteAll(self.Rows); nil != err {
+		return err
+	}
+	return writer.Error()
+}
+
 func (self *TransportEntity) Children() []TransportEntity {
 	ret := []TransportEntity{}
 	for _, resultRelation := range self.ChildRelations {
@@ -56,15 +92,18 @@ func (self *TransportEntity) Parents() []TransportEntity {
 
 func (self *TransportEntity) GetFieldByString(field string) string {
 	switch field {
+	case "Type":
+		return self.Type
 	case "ID":
 		return strconv.Itoa(self.ID)
 	case "Context":
 		return self.Context
 	case "Value":
 		return self.Value
+	case "Version":
+		return strconv.Itoa(self.Version)
 	default:
 		if -1 != strings.Index(field, "Properties") {
-			// ### we need to prepare the map here if it doesn't exist
 			property := field[11:]
 			if nil != self.Properties {
 				if val, ok := self.Properties[property]; ok {